@@ -8,4 +8,22 @@ type Configs struct {
 	DataSource   string `yaml:"data_source"`
 	Sault        string `yaml:"sault"`
 	DefaultGroup int    `yaml:"default_group"`
+	//urls of judgers registered on start up
+	Judgers []string `yaml:"judgers"`
+	//number of tasks each judger in Judgers runs concurrently
+	JudgerSlots int `yaml:"judger_slots"`
+	//interval of judger health checks in seconds, non-positive to disable
+	JudgerProbe int `yaml:"judger_probe"`
+	//minutes before contests start to sync their problem data to judgers, non-positive to disable
+	JudgerPrefetch int `yaml:"judger_prefetch"`
+	//deadline in seconds of each judge mode ("pretest", "tests", "extra", "custom_test"), non-positive for no deadline
+	JudgeTimeout map[string]int `yaml:"judge_timeout"`
+	//times an entry is requeued after missing the deadline before it is marked as an internal error
+	JudgeRetries int `yaml:"judge_retries"`
+	//pending entries gain priority each time they have waited this many seconds, non-positive to disable
+	JudgeAging int `yaml:"judge_aging"`
+	//seconds the results of custom tests are kept after they are finished, non-positive to keep them forever
+	CustomTestExpire int `yaml:"custom_test_expire"`
+	//shared secret to sign judge results, see internal.JudgeSignature
+	JudgerSecret string `yaml:"judger_secret"`
 }
\ No newline at end of file
@@ -1,6 +1,9 @@
 package config
 
-import "flag"
+import (
+	"flag"
+	"strings"
+)
 
 var configFile string
 var genConfig bool
@@ -15,6 +18,16 @@ func init() {
 	flag.StringVar(&Global.DataSource, "datasource", "yaoj@tcp(127.0.0.1:3306)/yaoj?charset=utf8mb4&parseTime=True&multiStatements=true", "data source name")
 	flag.StringVar(&Global.Sault, "sault", "3.1y4a1o5j9", "password sault")
 	flag.IntVar(&Global.DefaultGroup, "default-group", 1, "default permission group")
+	Global.Judgers = []string{"http://localhost:3000"}
+	flag.Var((*stringList)(&Global.Judgers), "judgers", "comma-separated judger urls")
+	flag.IntVar(&Global.JudgerSlots, "judger-slots", 1, "number of tasks each judger runs concurrently")
+	flag.IntVar(&Global.JudgerProbe, "judger-probe", 10, "interval of judger health checks in seconds")
+	flag.IntVar(&Global.JudgerPrefetch, "judger-prefetch", 60, "minutes before contests start to sync their problem data to judgers")
+	Global.JudgeTimeout = map[string]int{"pretest": 120, "tests": 600, "extra": 600, "custom_test": 60}
+	flag.IntVar(&Global.JudgeRetries, "judge-retries", 2, "times an entry is requeued after the judger misses the deadline")
+	flag.IntVar(&Global.JudgeAging, "judge-aging", 300, "seconds a pending entry waits before gaining priority")
+	flag.IntVar(&Global.CustomTestExpire, "custom-test-expire", 3600, "seconds the results of custom tests are kept, non-positive to keep them forever")
+	flag.StringVar(&Global.JudgerSecret, "judger-secret", "", "shared secret to sign judge results, required by judgers")
 	flag.StringVar(&configFile, "config", "", "config file")
 	flag.BoolVar(&genConfig, "genconfig", false, "generate default config file")
 }
@@ -25,4 +38,16 @@ func GenConfig() bool {
 
 func ConfigFile() string {
 	return configFile
-}
\ No newline at end of file
+}
+
+// comma-separated list of strings
+type stringList []string
+
+func (r *stringList) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *stringList) Set(value string) error {
+	*r = strings.Split(value, ",")
+	return nil
+}
@@ -3,6 +3,8 @@ package controllers
 import (
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 	"yao/internal"
 
 	"github.com/gin-gonic/gin"
@@ -16,7 +18,11 @@ func FinishJudging(ctx *gin.Context) {
 		result, err = io.ReadAll(ctx.Request.Body)
 		return err
 	}).Then(func() error {
-		return internal.FinishJudging(ctx.Query("jid"), result)
+		sid, err := strconv.Atoi(ctx.Query("sid"))
+		if err != nil {
+			return err
+		}
+		return internal.FinishJudging(ctx.Query("jid"), sid, ctx.Query("mode"), ctx.GetHeader("X-Signature"), result)
 	}).Catch(func(err error) {
 		(&Context{Context: ctx}).ErrorRPC(err)
 	})
@@ -30,3 +36,255 @@ func JudgerLog(ctx *Context, param JudgerLogParam) {
 	log := internal.JudgerLog(param.Id)
 	ctx.JSONAPI(http.StatusOK, "", map[string]any{"log": log})
 }
+
+type JudgerLogStreamParam struct {
+	Auth
+	Id     int `query:"judger_id" validate:"required"`
+	SubmID int `query:"submission_id"`
+}
+
+// Tail the log of a judger as server-sent events, starting with recent lines kept by the server.
+func JudgerLogStream(ctx *Context, param JudgerLogStreamParam) {
+	param.NewPermit().AsAdmin().Success(func(any) {
+		lines, ch, cancel := internal.JudgerLogFollow(param.Id, param.SubmID)
+		defer cancel()
+		for _, line := range lines {
+			ctx.SSEvent("log", line)
+		}
+		ctx.Writer.Flush()
+		ctx.Stream(func(io.Writer) bool {
+			select {
+			case line := <-ch:
+				ctx.SSEvent("log", line)
+				return true
+			case <-ctx.Request.Context().Done():
+				return false
+			}
+		})
+	}).FailAPIStatusForbidden(ctx)
+}
+
+type JudgerListParam struct {
+	Auth
+}
+
+func JudgerList(ctx *Context, param JudgerListParam) {
+	param.NewPermit().AsAdmin().Success(func(any) {
+		ctx.JSONAPI(http.StatusOK, "", map[string]any{"data": internal.JudgerList()})
+	}).FailAPIStatusForbidden(ctx)
+}
+
+type JudgerAddParam struct {
+	Auth
+	//name of the judger if it's a pull judger
+	Url   string `body:"url" validate:"required"`
+	Slots int    `body:"slots" validate:"gte=0"`
+	//pull judgers are registered on their first lease, removed ones have to be added again
+	Pull bool `body:"pull"`
+	//capabilities of the judger, langs and tags are comma-separated, see internal.JudgerCaps
+	Langs     string `body:"langs"`
+	MaxMemory int    `body:"max_memory"`
+	Tags      string `body:"tags"`
+}
+
+func JudgerAdd(ctx *Context, param JudgerAddParam) {
+	param.NewPermit().AsAdmin().Success(func(any) {
+		caps, err := internal.JudgerCapsParse(param.Langs, param.MaxMemory, param.Tags)
+		if err != nil {
+			ctx.JSONAPI(http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		add := internal.JudgerAdd
+		if param.Pull {
+			add = internal.JudgerPullAdd
+		}
+		judger, err := add(param.Url, param.Slots, caps)
+		if err != nil {
+			ctx.JSONAPI(http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		ctx.JSONAPI(http.StatusOK, "", map[string]any{"judger_id": judger.Id})
+	}).FailAPIStatusForbidden(ctx)
+}
+
+type JudgerDelParam struct {
+	Auth
+	Id int `query:"judger_id" validate:"required"`
+}
+
+func JudgerDel(ctx *Context, param JudgerDelParam) {
+	param.NewPermit().AsAdmin().Success(func(any) {
+		err := internal.JudgerRemove(param.Id)
+		if err != nil {
+			ctx.JSONAPI(http.StatusNotFound, err.Error(), nil)
+		}
+	}).FailAPIStatusForbidden(ctx)
+}
+
+type JudgerLeaseParam struct {
+	Name string `body:"name" validate:"required"`
+	Time string `body:"time" validate:"required"`
+	Wait int    `body:"wait" validate:"gte=0,lte=60"`
+	//number of leases the judger holds at the same time
+	Slots int `body:"slots" validate:"gte=0"`
+	//capabilities of the judger, langs and tags are comma-separated, see internal.JudgerCaps
+	Langs     string `body:"langs"`
+	MaxMemory int    `body:"max_memory"`
+	Tags      string `body:"tags"`
+}
+
+// Long-polling rpc for pull judgers, signature is given in header X-Signature
+func JudgerLease(ctx *Context, param JudgerLeaseParam) {
+	err := internal.JudgerLeaseCheck(param.Name, param.Time, ctx.GetHeader("X-Signature"))
+	if err != nil {
+		ctx.JSONRPC(http.StatusForbidden, -32600, err.Error(), nil)
+		return
+	}
+	caps, err := internal.JudgerCapsParse(param.Langs, param.MaxMemory, param.Tags)
+	if err != nil {
+		ctx.JSONRPC(http.StatusBadRequest, -32600, err.Error(), nil)
+		return
+	}
+	lease, err := internal.JudgerLease(param.Name, param.Slots, caps, time.Duration(param.Wait)*time.Second)
+	if err != nil {
+		ctx.ErrorRPC(err)
+		return
+	}
+	ctx.JSONRPC(http.StatusOK, 0, "", map[string]any{"lease": lease})
+}
+
+type JudgerLeaseTokenParam struct {
+	Token string `body:"token" validate:"required"`
+}
+
+func JudgerLeaseContent(ctx *Context, param JudgerLeaseTokenParam) {
+	content, err := internal.JudgerLeaseContent(param.Token)
+	if err != nil {
+		ctx.JSONRPC(http.StatusNotFound, -32600, err.Error(), nil)
+		return
+	}
+	ctx.Data(http.StatusOK, "binary", content)
+}
+
+func JudgerLeaseData(ctx *Context, param JudgerLeaseTokenParam) {
+	prob, err := internal.JudgerLeaseProblem(param.Token)
+	if err != nil {
+		ctx.JSONRPC(http.StatusNotFound, -32600, err.Error(), nil)
+		return
+	}
+	serveData(ctx, prob)
+}
+
+// Serve the data zip of a problem without holding the lock during the transfer, range requests are supported to resume.
+func serveData(ctx *Context, prob int) {
+	file, err := internal.ProbOpenData(prob)
+	if err != nil {
+		ctx.JSONRPC(http.StatusNotFound, -32600, err.Error(), nil)
+		return
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		ctx.ErrorRPC(err)
+		return
+	}
+	http.ServeContent(ctx.Writer, ctx.Request, stat.Name(), stat.ModTime(), file)
+}
+
+type JudgerPrefetchParam struct {
+	Name string `body:"name" validate:"required"`
+	Time string `body:"time" validate:"required"`
+}
+
+// Problems a pull judger can fetch in advance, signed the same as JudgerLease
+func JudgerPrefetch(ctx *Context, param JudgerPrefetchParam) {
+	err := internal.JudgerLeaseCheck(param.Name, param.Time, ctx.GetHeader("X-Signature"))
+	if err != nil {
+		ctx.JSONRPC(http.StatusForbidden, -32600, err.Error(), nil)
+		return
+	}
+	list, err := internal.JudgePrefetchList()
+	if err != nil {
+		ctx.ErrorRPC(err)
+		return
+	}
+	ctx.JSONRPC(http.StatusOK, 0, "", map[string]any{"problems": list})
+}
+
+type JudgerPrefetchDataParam struct {
+	Name   string `body:"name" validate:"required"`
+	Time   string `body:"time" validate:"required"`
+	ProbID int    `body:"problem_id" validate:"required"`
+}
+
+func JudgerPrefetchData(ctx *Context, param JudgerPrefetchDataParam) {
+	err := internal.JudgerLeaseCheck(param.Name, param.Time, ctx.GetHeader("X-Signature"))
+	if err != nil {
+		ctx.JSONRPC(http.StatusForbidden, -32600, err.Error(), nil)
+		return
+	}
+	if !internal.JudgePrefetchHas(param.ProbID) {
+		ctx.JSONRPC(http.StatusNotFound, -32600, "problem is not in upcoming contests", nil)
+		return
+	}
+	serveData(ctx, param.ProbID)
+}
+
+type JudgerDrainParam struct {
+	Auth
+	Id    int  `body:"judger_id" validate:"required"`
+	Drain bool `body:"drain"`
+}
+
+func JudgerDrain(ctx *Context, param JudgerDrainParam) {
+	param.NewPermit().AsAdmin().Success(func(any) {
+		err := internal.JudgerDrain(param.Id, param.Drain)
+		if err != nil {
+			ctx.JSONAPI(http.StatusNotFound, err.Error(), nil)
+		}
+	}).FailAPIStatusForbidden(ctx)
+}
+
+type JudgeQueueGetParam struct {
+	Auth
+}
+
+func JudgeQueueGet(ctx *Context, param JudgeQueueGetParam) {
+	param.NewPermit().AsAdmin().Success(func(any) {
+		entries, err := internal.JudgeQueueList()
+		if err != nil {
+			ctx.ErrorAPI(err)
+			return
+		}
+		ctx.JSONAPI(http.StatusOK, "", map[string]any{"data": entries})
+	}).FailAPIStatusForbidden(ctx)
+}
+
+type JudgeQueueEditParam struct {
+	Auth
+	Id       int64 `body:"id" validate:"required"`
+	Priority int   `body:"priority"`
+}
+
+func JudgeQueueEdit(ctx *Context, param JudgeQueueEditParam) {
+	param.NewPermit().AsAdmin().Success(func(any) {
+		err := internal.JudgeQueueSetPriority(param.Id, param.Priority)
+		if err != nil {
+			ctx.JSONAPI(http.StatusBadRequest, err.Error(), nil)
+		}
+	}).FailAPIStatusForbidden(ctx)
+}
+
+type JudgeQueueDelParam struct {
+	Auth
+	Id int64 `query:"id" validate:"required"`
+}
+
+func JudgeQueueDel(ctx *Context, param JudgeQueueDelParam) {
+	param.NewPermit().AsAdmin().Success(func(any) {
+		err := internal.JudgeQueueCancel(param.Id)
+		if err != nil {
+			ctx.JSONAPI(http.StatusBadRequest, err.Error(), nil)
+		}
+	}).FailAPIStatusForbidden(ctx)
+}
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"net/http"
+	"yao/internal"
+)
+
+type PlagStartParam struct {
+	Auth
+	CtstID    int     `body:"contest_id"`
+	ProbID    int     `body:"problem_id"`
+	Threshold float64 `body:"threshold" validate:"gte=0,lte=1"`
+}
+
+func PlagStart(ctx *Context, param PlagStartParam) {
+	param.NewPermit().AsAdmin().Success(func(any) {
+		if param.CtstID == 0 && param.ProbID == 0 {
+			ctx.JSONAPI(http.StatusBadRequest, "contest_id or problem_id is required", nil)
+			return
+		}
+		id, err := internal.PlagiarismStart(param.CtstID, param.ProbID, param.Threshold)
+		if err != nil {
+			ctx.ErrorAPI(err)
+			return
+		}
+		ctx.JSONAPI(http.StatusOK, "", map[string]any{"job_id": id})
+	}).FailAPIStatusForbidden(ctx)
+}
+
+type PlagListParam struct {
+	Auth
+	CtstID int `query:"contest_id"`
+	ProbID int `query:"problem_id"`
+}
+
+func PlagList(ctx *Context, param PlagListParam) {
+	permit := param.NewPermit()
+	if param.CtstID > 0 {
+		permit.TryEditCtst(param.CtstID)
+	} else if param.ProbID > 0 {
+		permit.TryEditProb(param.ProbID)
+	} else {
+		ctx.JSONAPI(http.StatusBadRequest, "contest_id or problem_id is required", nil)
+		return
+	}
+	permit.Success(func(any) {
+		jobs, err := internal.PlagiarismList(param.CtstID, param.ProbID)
+		if err != nil {
+			ctx.ErrorAPI(err)
+			return
+		}
+		ctx.JSONAPI(http.StatusOK, "", map[string]any{"data": jobs})
+	}).FailAPIStatusForbidden(ctx)
+}
+
+type PlagGetParam struct {
+	Auth
+	JobID int `query:"job_id" validate:"required"`
+}
+
+// A job with its flagged pairs, and the submissions in these pairs
+func PlagGet(ctx *Context, param PlagGetParam) {
+	param.NewPermit().TryReviewPlag(param.JobID).Success(func(a any) {
+		pairs, err := internal.PlagiarismPairs(param.JobID)
+		if err != nil {
+			ctx.ErrorAPI(err)
+			return
+		}
+		sids, seen := []int{}, make(map[int]bool)
+		for _, pair := range pairs {
+			for _, sid := range []int{pair.SubmA, pair.SubmB} {
+				if !seen[sid] {
+					seen[sid] = true
+					sids = append(sids, sid)
+				}
+			}
+		}
+		ctx.JSONAPI(http.StatusOK, "", map[string]any{
+			"job": a, "pairs": pairs, "submissions": internal.SubmListByIds(sids),
+		})
+	}).FailAPIStatusForbidden(ctx)
+}
+
+type PlagPairGetParam struct {
+	Auth
+	JobID int `query:"job_id" validate:"required"`
+	SubmA int `query:"submission_a" validate:"required"`
+	SubmB int `query:"submission_b" validate:"required"`
+}
+
+// A flagged pair with the diff between the two submissions
+func PlagPairGet(ctx *Context, param PlagPairGetParam) {
+	param.NewPermit().TryReviewPlag(param.JobID).Success(func(any) {
+		pair, err := internal.PlagiarismPairQuery(param.JobID, param.SubmA, param.SubmB)
+		if err != nil {
+			ctx.JSONAPI(http.StatusNotFound, "no such pair", nil)
+			return
+		}
+		diff, err := internal.SubmDiff(pair.SubmA, pair.SubmB)
+		if err != nil {
+			ctx.ErrorAPI(err)
+			return
+		}
+		ctx.JSONAPI(http.StatusOK, "", map[string]any{
+			"pair": pair, "submissions": internal.SubmListByIds([]int{pair.SubmA, pair.SubmB}), "diff": diff,
+		})
+	}).FailAPIStatusForbidden(ctx)
+}
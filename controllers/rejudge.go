@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"net/http"
+	"yao/internal"
+)
+
+type RejudgeJobAddParam struct {
+	Auth
+	CtstID   int    `body:"contest_id"`
+	ProbID   int    `body:"problem_id"`
+	Submtter int    `body:"submitter"`
+	Verdict  string `body:"verdict"`
+	Status   *int   `body:"status"`
+	//submit time range in format "2006-01-02 15:04:05"
+	After  string `body:"after"`
+	Before string `body:"before"`
+}
+
+// Admins can rejudge any submissions, managers can rejudge those of their problems or contests
+func RejudgeJobAdd(ctx *Context, param RejudgeJobAddParam) {
+	if param.Verdict != "" && !internal.VerdictValid(param.Verdict) {
+		ctx.JSONAPI(http.StatusBadRequest, "invalid verdict", nil)
+		return
+	}
+	filter := internal.SubmFilter{
+		Submitter: param.Submtter,
+		ProblemId: param.ProbID,
+		ContestId: param.CtstID,
+		Status:    param.Status,
+		Verdict:   param.Verdict,
+	}
+	if !parseTimeRange(ctx, param.After, param.Before, &filter) {
+		return
+	}
+	param.NewPermit().Try(func() (any, bool) {
+		return nil, param.IsAdmin() ||
+			(param.ProbID > 0 && param.CanEditProb(param.ProbID)) ||
+			(param.CtstID > 0 && param.CanEditCtst(param.CtstID))
+	}).Success(func(any) {
+		id, err := internal.RejudgeStart(param.UserID, filter)
+		if err != nil {
+			ctx.ErrorAPI(err)
+			return
+		}
+		ctx.JSONAPI(http.StatusOK, "", map[string]any{"job_id": id})
+	}).FailAPIStatusForbidden(ctx)
+}
+
+type RejudgeJobParam struct {
+	Auth
+	JobID int `query:"job_id" validate:"required"`
+}
+
+func RejudgeJobGet(ctx *Context, param RejudgeJobParam) {
+	param.NewPermit().TryManageRejudge(param.JobID).Success(func(a any) {
+		ctx.JSONAPI(http.StatusOK, "", map[string]any{"job": a})
+	}).FailAPIStatusForbidden(ctx)
+}
+
+func RejudgeJobCancel(ctx *Context, param RejudgeJobParam) {
+	param.NewPermit().TryManageRejudge(param.JobID).Success(func(any) {
+		err := internal.RejudgeCancel(param.JobID)
+		if err != nil {
+			ctx.ErrorAPI(err)
+		}
+	}).FailAPIStatusForbidden(ctx)
+}
+
+// Submissions and standings changed by a finished job
+func RejudgeReport(ctx *Context, param RejudgeJobParam) {
+	param.NewPermit().TryManageRejudge(param.JobID).Success(func(any) {
+		report, err := internal.RejudgeReportOf(param.JobID)
+		if err != nil {
+			ctx.JSONAPI(http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		ctx.JSONAPI(http.StatusOK, "", map[string]any{"data": report})
+	}).FailAPIStatusForbidden(ctx)
+}
+
+type RejudgeJobListParam struct {
+	Auth
+}
+
+// Recent jobs, admins see jobs of all users
+func RejudgeJobList(ctx *Context, param RejudgeJobListParam) {
+	param.NewPermit().AsNormalUser().Success(func(any) {
+		creator := param.UserID
+		if param.IsAdmin() {
+			creator = 0
+		}
+		jobs, err := internal.RejudgeList(creator, 100)
+		if err != nil {
+			ctx.ErrorAPI(err)
+			return
+		}
+		ctx.JSONAPI(http.StatusOK, "", map[string]any{"data": jobs})
+	}).FailAPIStatusForbidden(ctx)
+}
@@ -22,6 +22,12 @@ var Router = map[string]RestApi{
 	"/FinishContest": {"POST": server.GeneralHandler(CtstFinish)},
 	"/judgerlog":     {"GET": server.GeneralHandler(JudgerLog)},
 
+	"/JudgerLease":        {"POST": server.GeneralHandler(JudgerLease)},
+	"/JudgerLeaseContent": {"POST": server.GeneralHandler(JudgerLeaseContent)},
+	"/JudgerLeaseData":    {"POST": server.GeneralHandler(JudgerLeaseData)},
+	"/JudgerPrefetch":     {"POST": server.GeneralHandler(JudgerPrefetch)},
+	"/JudgerPrefetchData": {"POST": server.GeneralHandler(JudgerPrefetchData)},
+
 	"/user": {
 		"GET":   server.GeneralHandler(UserGet),
 		"POST":  server.GeneralHandler(UserSignUp),
@@ -133,7 +139,39 @@ var Router = map[string]RestApi{
 		"POST":   server.GeneralHandler(SubmAdd),
 		"DELETE": server.GeneralHandler(SubmDel),
 	},
-	"/custom_test": {"POST": server.GeneralHandler(SubmCustom)},
+	"/submission_stream": {"GET": server.GeneralHandler(SubmStream)},
+	"/submission_source": {"GET": server.GeneralHandler(SubmSource)},
+	"/submission_diff":   {"GET": server.GeneralHandler(SubmDiff)},
+	"/plagiarism": {
+		"GET":  server.GeneralHandler(PlagGet),
+		"POST": server.GeneralHandler(PlagStart),
+	},
+	"/plagiarism_jobs": {"GET": server.GeneralHandler(PlagList)},
+	"/plagiarism_pair": {"GET": server.GeneralHandler(PlagPairGet)},
+	"/rejudge_job": {
+		"GET":    server.GeneralHandler(RejudgeJobGet),
+		"POST":   server.GeneralHandler(RejudgeJobAdd),
+		"DELETE": server.GeneralHandler(RejudgeJobCancel),
+	},
+	"/rejudge_jobs":   {"GET": server.GeneralHandler(RejudgeJobList)},
+	"/rejudge_report": {"GET": server.GeneralHandler(RejudgeReport)},
+	"/custom_test": {
+		"GET":  server.GeneralHandler(SubmCustomGet),
+		"POST": server.GeneralHandler(SubmCustom),
+	},
+
+	"/judgers": {
+		"GET":    server.GeneralHandler(JudgerList),
+		"POST":   server.GeneralHandler(JudgerAdd),
+		"PATCH":  server.GeneralHandler(JudgerDrain),
+		"DELETE": server.GeneralHandler(JudgerDel),
+	},
+	"/judgerlog_stream": {"GET": server.GeneralHandler(JudgerLogStream)},
+	"/judge_queue": {
+		"GET":    server.GeneralHandler(JudgeQueueGet),
+		"PATCH":  server.GeneralHandler(JudgeQueueEdit),
+		"DELETE": server.GeneralHandler(JudgeQueueDel),
+	},
 }
 
 type GetTimeParam struct {
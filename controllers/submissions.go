@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"path"
 	"time"
 	"yao/db"
 	"yao/internal"
@@ -18,15 +19,43 @@ import (
 type SubmListParam struct {
 	Auth
 	Page     `validate:"pagecanbound"`
-	ProbID   int `query:"problem_id"`
-	CtstID   int `query:"contest_id"`
-	Submtter int `query:"submitter"`
+	ProbID   int      `query:"problem_id"`
+	CtstID   int      `query:"contest_id"`
+	Submtter int      `query:"submitter"`
+	Language *int     `query:"language"`
+	Status   *int     `query:"status"`
+	Verdict  string   `query:"verdict"`
+	Test     int      `query:"test" validate:"gte=0"`
+	MinScore *float64 `query:"min_score"`
+	MaxScore *float64 `query:"max_score"`
+	//submit time range in format "2006-01-02 15:04:05"
+	After    string `query:"after"`
+	Before   string `query:"before"`
+	Accepted bool   `query:"accepted"`
 }
 
 func SubmList(ctx *Context, param SubmListParam) {
+	if param.Verdict != "" && !internal.VerdictValid(param.Verdict) {
+		ctx.JSONAPI(http.StatusBadRequest, "invalid verdict", nil)
+		return
+	}
+	filter := internal.SubmFilter{
+		Submitter: param.Submtter,
+		ProblemId: param.ProbID,
+		ContestId: param.CtstID,
+		Language:  param.Language,
+		Status:    param.Status,
+		Verdict:   param.Verdict,
+		Test:      param.Test,
+		MinScore:  param.MinScore,
+		MaxScore:  param.MaxScore,
+		Accepted:  param.Accepted,
+	}
+	if !parseTimeRange(ctx, param.After, param.Before, &filter) {
+		return
+	}
 	submissions, isfull, err := internal.SubmList(
-		param.Bound(), *param.PageSize, param.UserID, param.Submtter, param.ProbID, param.CtstID,
-		param.IsLeft(), param.IsAdmin(),
+		param.Bound(), *param.PageSize, param.UserID, filter, param.IsLeft(), param.IsAdmin(),
 	)
 	if err != nil {
 		ctx.ErrorAPI(err)
@@ -42,6 +71,25 @@ func SubmList(ctx *Context, param SubmListParam) {
 	ctx.JSONAPI(http.StatusOK, "", map[string]any{"data": submissions, "isfull": isfull})
 }
 
+// Parse the submit time range of a filter, returns false if it's malformed
+func parseTimeRange(ctx *Context, after, before string, filter *internal.SubmFilter) bool {
+	for _, t := range []struct {
+		str string
+		ptr **time.Time
+	}{{after, &filter.After}, {before, &filter.Before}} {
+		if t.str == "" {
+			continue
+		}
+		tm, err := time.Parse("2006-01-02 15:04:05", t.str)
+		if err != nil {
+			ctx.JSONAPI(http.StatusBadRequest, "time format error", nil)
+			return false
+		}
+		*t.ptr = &tm
+	}
+	return true
+}
+
 type SubmAddParam struct {
 	Auth
 	ProbID  int     `body:"problem_id" validate:"required"`
@@ -126,13 +174,41 @@ func parseZipFile(ctx *Context, field string, config internal.SubmConfig) (probl
 			ctx.JSONAPI(http.StatusBadRequest, "no field matches file name: "+name, nil)
 			return nil, nil, 0, 0
 		}
-		//TODO: get language by file suffix
-		preview[matched] = getPreview(val, config[matched].Accepted, -1)
+		lang := -1
+		if config[matched].Accepted == utils.Csource {
+			tag, ok := langBySuffix(name[len(matched):], config[matched].Langs)
+			if ok {
+				lang = int(tag)
+				language = lang
+			} else if config[matched].Langs != nil {
+				ctx.JSONAPI(http.StatusBadRequest, "language of file "+name+" is not allowed", nil)
+				return nil, nil, 0, 0
+			}
+		}
+		preview[matched] = getPreview(val, config[matched].Accepted, utils.LangTag(lang))
 		sub.SetSource(workflow.Gsubm, matched, name, bytes.NewReader(val))
 	}
 	return sub, preview, utils.LangTag(language), length
 }
 
+/*
+Get language by file suffix, which is the reverse of utils.LangSuf. Several
+languages share a suffix (e.g. standards of C++), the allowed one with the
+largest tag (i.e. the newest standard) is chosen. allowed is nil if all
+languages are allowed.
+*/
+func langBySuffix(suffix string, allowed []utils.LangTag) (utils.LangTag, bool) {
+	for i := len(utils.LangSuf) - 1; i >= 0; i-- {
+		if utils.LangSuf[i] != suffix {
+			continue
+		}
+		if allowed == nil || utils.HasElement(allowed, utils.LangTag(i)) {
+			return utils.LangTag(i), true
+		}
+	}
+	return -1, false
+}
+
 /*
 When user submits files one by one
 */
@@ -221,19 +297,138 @@ type SubmGetParam struct {
 func SubmGet(ctx *Context, param SubmGetParam) {
 	param.NewPermit().TrySeeSubm(param.SubmID).Success(func(a any) {
 		psubm := a.(PermitSubm)
-		//user cannot see submission details inside contests
-		if !psubm.CanEdit && !psubm.ByProb {
-			if internal.CTPretestOnly(psubm.ContestId) {
-				internal.SubmPretestOnly(&psubm.Submission)
-			} else {
-				psubm.Details.Result = internal.SubmRemoveTestDetails(psubm.Details.Result)
-				psubm.Details.ExtraResult = internal.SubmRemoveTestDetails(psubm.Details.ExtraResult)
+		submMask(&psubm)
+		queue := internal.JudgeQueuePositions(psubm.Id, psubm.Uuid)
+		ctx.JSONAPI(http.StatusOK, "", map[string]any{"submission": psubm.Submission, "can_edit": psubm.CanEdit, "queue": queue})
+	}).FailAPIStatusForbidden(ctx)
+}
+
+type SubmSourceParam struct {
+	Auth
+	SubmID int `query:"submission_id" validate:"required"`
+	//name of a single file in the zip, download the whole zip if it's empty
+	File string `query:"file"`
+}
+
+// Download the original submitted files
+func SubmSource(ctx *Context, param SubmSourceParam) {
+	param.NewPermit().TrySeeSubmSource(param.SubmID).Success(func(any) {
+		if param.File == "" {
+			content, err := internal.SubmContent(param.SubmID)
+			if err != nil {
+				ctx.ErrorAPI(err)
+				return
 			}
+			ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"submission_%d.zip\"", param.SubmID))
+			ctx.Data(http.StatusOK, "application/zip", content)
+			return
+		}
+		files, err := internal.SubmSourceFiles(param.SubmID)
+		if err != nil {
+			ctx.ErrorAPI(err)
+			return
+		}
+		content, ok := files[param.File]
+		if !ok {
+			ctx.JSONAPI(http.StatusNotFound, "no such file", nil)
+			return
+		}
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(param.File)))
+		ctx.Data(http.StatusOK, "application/octet-stream", content)
+	}).FailAPIStatusForbidden(ctx)
+}
+
+type SubmDiffParam struct {
+	Auth
+	From int `query:"from" validate:"required"`
+	To   int `query:"to" validate:"required"`
+}
+
+// Unified diffs between files of two submissions, indexed by fields
+func SubmDiff(ctx *Context, param SubmDiffParam) {
+	param.NewPermit().TrySeeSubmSource(param.From).TrySeeSubmSource(param.To).Success(func(any) {
+		diff, err := internal.SubmDiff(param.From, param.To)
+		if err != nil {
+			ctx.ErrorAPI(err)
+			return
 		}
-		ctx.JSONAPI(http.StatusOK, "", map[string]any{"submission": psubm.Submission, "can_edit": psubm.CanEdit})
+		ctx.JSONAPI(http.StatusOK, "", map[string]any{"data": diff})
+	}).FailAPIStatusForbidden(ctx)
+}
+
+type SubmStreamParam struct {
+	Auth
+	SubmID   int `query:"submission_id"`
+	Submtter int `query:"submitter"`
+}
+
+/*
+Push changes of a submission, or of all submissions by a submitter, as server-sent
+events named after SubmEvent.Event. Watching a submission starts with its current
+state in a "state" event. Submissions invisible to the user are skipped.
+*/
+func SubmStream(ctx *Context, param SubmStreamParam) {
+	if param.SubmID == 0 && param.Submtter == 0 {
+		ctx.JSONAPI(http.StatusBadRequest, "submission_id or submitter is required", nil)
+		return
+	}
+	stream := func() {
+		ch, cancel := internal.SubmWatch(param.SubmID, param.Submtter)
+		defer cancel()
+		ctx.Writer.Flush()
+		ctx.Stream(func(io.Writer) bool {
+			select {
+			case ev := <-ch:
+				if submEventVisible(&param, &ev) {
+					ctx.SSEvent(ev.Event, ev)
+				}
+				return true
+			case <-ctx.Request.Context().Done():
+				return false
+			}
+		})
+	}
+	if param.SubmID == 0 {
+		stream()
+		return
+	}
+	param.NewPermit().TrySeeSubm(param.SubmID).Success(func(a any) {
+		psubm := a.(PermitSubm)
+		submMask(&psubm)
+		ctx.SSEvent("state", internal.SubmEvent{Event: "state", Submission: psubm.Submission})
+		stream()
 	}).FailAPIStatusForbidden(ctx)
 }
 
+// Check the visibility of the event with the same rules as SubmGet
+func submEventVisible(param *SubmStreamParam, ev *internal.SubmEvent) bool {
+	visible := false
+	param.NewPermit().TrySeeSubm(ev.Submission.Id).Success(func(a any) {
+		psubm := a.(PermitSubm)
+		psubm.Submission = ev.Submission
+		submMask(&psubm)
+		ev.Submission = psubm.Submission
+		visible = true
+	})
+	return visible
+}
+
+// Hide judge errors and details of tests from users who can't edit the submission
+func submMask(psubm *PermitSubm) {
+	if !psubm.CanEdit {
+		psubm.Details.JudgeError = ""
+	}
+	//user cannot see submission details inside contests
+	if !psubm.CanEdit && !psubm.ByProb {
+		if internal.CTPretestOnly(psubm.ContestId) {
+			internal.SubmPretestOnly(&psubm.Submission)
+		} else {
+			psubm.Details.Result = internal.SubmRemoveTestDetails(psubm.Details.Result)
+			psubm.Details.ExtraResult = internal.SubmRemoveTestDetails(psubm.Details.ExtraResult)
+		}
+	}
+}
+
 type SubmCustomParam struct {
 	Auth
 }
@@ -244,13 +439,35 @@ func SubmCustom(ctx *Context, param SubmCustomParam) {
 			"source": {Langs: nil, Accepted: utils.Csource, Length: 64 * 1024},
 			"input":  {Langs: nil, Accepted: utils.Cplain, Length: 10 * 1024 * 1024},
 		}
-		subm, _, _, _ := parseMultiFiles(ctx, config)
+		subm, _, language, _ := parseMultiFiles(ctx, config)
 		if subm == nil {
 			return
 		}
 		w := bytes.NewBuffer(nil)
 		subm.DumpTo(w)
-		result := internal.SubmJudgeCustomTest(w.Bytes())
+		id, err := internal.SubmJudgeCustomTest(param.UserID, language, w.Bytes())
+		if err != nil {
+			ctx.ErrorAPI(err)
+			return
+		}
+		ctx.JSONAPI(http.StatusOK, "", map[string]any{"id": id})
+	}).FailAPIStatusForbidden(ctx)
+}
+
+type SubmCustomGetParam struct {
+	Auth
+	Id int `query:"id" validate:"required"`
+}
+
+// Query the result of a custom test, result is absent if it's not judged yet.
+func SubmCustomGet(ctx *Context, param SubmCustomGetParam) {
+	param.NewPermit().TrySeeCustomTest(param.Id).Success(func(a any) {
+		test := a.(internal.CustomTest)
+		if test.FinishTime == nil {
+			ctx.JSONAPI(http.StatusOK, "", map[string]any{"finished": false})
+			return
+		}
+		result := test.Result
 		if len(result) == 0 {
 			result, _ = json.Marshal(map[string]any{
 				"Memory": -1,
@@ -258,7 +475,7 @@ func SubmCustom(ctx *Context, param SubmCustomParam) {
 				"Title":  "Internal Error",
 			})
 		}
-		ctx.JSONAPI(http.StatusOK, "", map[string]any{"result": string(result)})
+		ctx.JSONAPI(http.StatusOK, "", map[string]any{"finished": true, "result": string(result)})
 	}).FailAPIStatusForbidden(ctx)
 }
 
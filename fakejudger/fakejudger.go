@@ -0,0 +1,316 @@
+/*
+Package fakejudger implements a judger speaking the same protocol as yaoj
+judgers (/judge, /sync, /custom and /log), so the whole flow from submission
+to standing can run in go test without a real judger.
+
+Results, delays and failures of each call are decided by Judger.Plan:
+
+	config.Global.JudgerSecret = "secret"
+	fake := fakejudger.New("secret")
+	fake.Plan = func(call fakejudger.Call) fakejudger.Plan {
+		return fakejudger.Plan{Result: fakejudger.Accepted(3, 100)}
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	judger, err := internal.JudgerAdd(server.URL, 1, internal.JudgerCaps{})
+*/
+package fakejudger
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Testcase struct {
+	Title  string
+	Score  float64
+	Time   float64
+	Memory float64
+}
+
+type Subtask struct {
+	Fullscore float64
+	Testcase  []Testcase
+}
+
+// Result of judging a submission, in the format of yaoj judgers
+type Result struct {
+	IsSubtask bool
+	Subtask   []Subtask
+}
+
+// Result of a custom test, in the format of yaoj judgers
+type CustomResult struct {
+	Title  string
+	Time   float64
+	Memory float64
+}
+
+// A request to judge, from /judge or /custom
+type Call struct {
+	Sid      int
+	Mode     string //"custom_test" for /custom
+	CheckSum string
+	Content  []byte
+}
+
+type Failure int
+
+const (
+	//judge normally
+	None Failure = iota
+	//respond with an error instead of accepting the task
+	Refuse
+	//accept the task but never call back, like a judger crashing in the middle
+	Drop
+)
+
+// What the fake judger does with a call
+type Plan struct {
+	//result reported to the callback, marshalled into json if it's not a []byte
+	Result any
+	//time waited before calling back
+	Delay   time.Duration
+	Failure Failure
+}
+
+type Judger struct {
+	//decides what to do with each call, the default plan accepts with Accepted(1, 100)
+	Plan func(Call) Plan
+
+	secret string
+	client *http.Client
+	lock   sync.Mutex
+	//when down is true /log fails, so the judger is considered unhealthy
+	down bool
+	//check sums of synced problem data
+	synced map[string]bool
+	//problem data being synced, indexed by check sum
+	partial map[string][]byte
+	calls   []Call
+	log     bytes.Buffer
+	//callbacks in progress
+	wg sync.WaitGroup
+}
+
+// New fake judger signing callbacks with secret, the same as config.Global.JudgerSecret
+func New(secret string) *Judger {
+	return &Judger{
+		secret:  secret,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		synced:  make(map[string]bool),
+		partial: make(map[string][]byte),
+	}
+}
+
+// Result with a single subtask of n testcases all accepted, fullscore is shared among them
+func Accepted(n int, fullscore float64) Result {
+	sub := Subtask{Fullscore: fullscore}
+	for i := 0; i < n; i++ {
+		sub.Testcase = append(sub.Testcase, Testcase{Title: "Accepted", Score: fullscore / float64(n), Time: 1, Memory: 1024})
+	}
+	return Result{Subtask: []Subtask{sub}}
+}
+
+// Mark the problem data of check sum as synced, so /judge won't ask for it
+func (j *Judger) Sync(sum string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.synced[sum] = true
+}
+
+// Make /log fail or succeed, so the judger is considered unhealthy or healthy
+func (j *Judger) SetDown(down bool) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.down = down
+}
+
+// Copies of calls received so far
+func (j *Judger) Calls() []Call {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return append([]Call{}, j.calls...)
+}
+
+// Block until all callbacks in progress are sent
+func (j *Judger) Wait() {
+	j.wg.Wait()
+}
+
+type response struct {
+	Err     string `json:"error,omitempty"`
+	ErrCode int    `json:"error_code,omitempty"`
+	Msg     string `json:"message,omitempty"`
+	Offset  int64  `json:"offset"`
+}
+
+func (j *Judger) reply(w http.ResponseWriter, res response) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (j *Judger) logf(format string, args ...any) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	fmt.Fprintf(&j.log, time.Now().Format("15:04:05.000 ")+format+"\n", args...)
+}
+
+func (j *Judger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/judge":
+		j.judge(w, r, r.URL.Query().Get("mode"))
+	case "/custom":
+		j.judge(w, r, "custom_test")
+	case "/sync":
+		j.sync(w, r)
+	case "/log":
+		j.lock.Lock()
+		defer j.lock.Unlock()
+		if j.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(j.log.Bytes())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Chunked data sync, see the comments of internal.judger.sync
+func (j *Judger) sync(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sum := query.Get("sum")
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	have := int64(len(j.partial[sum]))
+	if r.Method == http.MethodGet {
+		j.reply(w, response{Msg: "ok", Offset: have})
+		return
+	}
+	offset, _ := strconv.ParseInt(query.Get("offset"), 10, 64)
+	size, _ := strconv.ParseInt(query.Get("size"), 10, 64)
+	if offset != have {
+		j.reply(w, response{Err: "offset mismatch", ErrCode: 3, Offset: have})
+		return
+	}
+	chunk, err := io.ReadAll(r.Body)
+	if err != nil {
+		j.reply(w, response{Err: err.Error(), ErrCode: 2, Offset: have})
+		return
+	}
+	hash := sha256.Sum256(chunk)
+	if hex.EncodeToString(hash[:]) != query.Get("hash") {
+		j.reply(w, response{Err: "hash mismatch", ErrCode: 2, Offset: have})
+		return
+	}
+	j.partial[sum] = append(j.partial[sum], chunk...)
+	if int64(len(j.partial[sum])) >= size {
+		j.synced[sum] = true
+	}
+	fmt.Fprintf(&j.log, "%s sync %s: %d/%d\n", time.Now().Format("15:04:05.000"), sum, len(j.partial[sum]), size)
+	j.reply(w, response{Msg: "ok", Offset: int64(len(j.partial[sum]))})
+}
+
+// Problem data synced with the check sum
+func (j *Judger) Data(sum string) []byte {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return append([]byte{}, j.partial[sum]...)
+}
+
+func (j *Judger) judge(w http.ResponseWriter, r *http.Request, mode string) {
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		j.reply(w, response{Err: err.Error(), ErrCode: 2})
+		return
+	}
+	cb := r.URL.Query().Get("cb")
+	cburl, err := url.Parse(cb)
+	if err != nil {
+		j.reply(w, response{Err: "invalid callback: " + err.Error(), ErrCode: 2})
+		return
+	}
+	sid, _ := strconv.Atoi(cburl.Query().Get("sid"))
+	call := Call{Sid: sid, Mode: mode, CheckSum: r.URL.Query().Get("sum"), Content: content}
+	if mode != "custom_test" {
+		j.lock.Lock()
+		synced := j.synced[call.CheckSum]
+		j.lock.Unlock()
+		if !synced {
+			j.reply(w, response{Err: "data not synced", ErrCode: 1})
+			return
+		}
+	}
+	j.lock.Lock()
+	j.calls = append(j.calls, call)
+	j.lock.Unlock()
+
+	plan := Plan{Result: Accepted(1, 100)}
+	if mode == "custom_test" {
+		plan.Result = CustomResult{Title: "Accepted", Time: 1, Memory: 1024}
+	}
+	if j.Plan != nil {
+		plan = j.Plan(call)
+	}
+	j.logf("%s submission %d: failure=%d delay=%v", mode, sid, plan.Failure, plan.Delay)
+	switch plan.Failure {
+	case Refuse:
+		j.reply(w, response{Err: "refused", ErrCode: 2})
+		return
+	case Drop:
+		j.reply(w, response{Msg: "ok"})
+		return
+	}
+	result, ok := plan.Result.([]byte)
+	if !ok {
+		result, err = json.Marshal(plan.Result)
+		if err != nil {
+			j.reply(w, response{Err: err.Error(), ErrCode: 2})
+			return
+		}
+	}
+	j.reply(w, response{Msg: "ok"})
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		time.Sleep(plan.Delay)
+		j.callback(cb, sid, mode, result)
+	}()
+}
+
+// Signature of the result, see internal.JudgeSignature
+func (j *Judger) signature(sid int, mode string, result []byte) string {
+	mac := hmac.New(sha256.New, []byte(j.secret))
+	fmt.Fprintf(mac, "%d\n%s\n", sid, mode)
+	mac.Write(result)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (j *Judger) callback(cb string, sid int, mode string, result []byte) {
+	req, err := http.NewRequest(http.MethodPost, cb, bytes.NewReader(result))
+	if err != nil {
+		j.logf("callback of submission %d: %v", sid, err)
+		return
+	}
+	req.Header.Set("X-Signature", j.signature(sid, mode, result))
+	res, err := j.client.Do(req)
+	if err != nil {
+		j.logf("callback of submission %d: %v", sid, err)
+		return
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	j.logf("callback of submission %d: %s", sid, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,122 @@
+package fakejudger
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type callback struct {
+	query     url.Values
+	signature string
+	body      []byte
+}
+
+func post(t *testing.T, u string, body []byte) response {
+	res, err := http.Post(u, "binary", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var ret response
+	if err := json.NewDecoder(res.Body).Decode(&ret); err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func TestJudge(t *testing.T) {
+	callbacks := make(chan callback, 1)
+	back := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		callbacks <- callback{r.URL.Query(), r.Header.Get("X-Signature"), body}
+	}))
+	defer back.Close()
+
+	judger := New("secret")
+	judger.Plan = func(call Call) Plan {
+		return Plan{Result: Accepted(2, 100), Delay: 10 * time.Millisecond}
+	}
+	server := httptest.NewServer(judger)
+	defer server.Close()
+
+	cb := back.URL + "/FinishJudging?" + url.Values{"jid": {"x"}, "sid": {"3"}, "mode": {"tests"}}.Encode()
+	judge := server.URL + "/judge?" + url.Values{"mode": {"tests"}, "sum": {"abc"}, "cb": {cb}}.Encode()
+	if res := post(t, judge, []byte("subm")); res.ErrCode != 1 {
+		t.Fatalf("expected to be asked for data, got %+v", res)
+	}
+	chunk := func(offset int, data string) response {
+		hash := sha256.Sum256([]byte(data))
+		return post(t, server.URL+"/sync?"+url.Values{
+			"sum":    {"abc"},
+			"offset": {fmt.Sprint(offset)},
+			"size":   {"8"},
+			"hash":   {hex.EncodeToString(hash[:])},
+		}.Encode(), []byte(data))
+	}
+	if res := chunk(0, "data"); res.Msg != "ok" || res.Offset != 4 {
+		t.Fatalf("sync failed: %+v", res)
+	}
+	if res := chunk(0, "data"); res.ErrCode != 3 || res.Offset != 4 {
+		t.Fatalf("expected offset mismatch, got %+v", res)
+	}
+	if res := chunk(4, "zip!"); res.Msg != "ok" || string(judger.Data("abc")) != "datazip!" {
+		t.Fatalf("sync failed: %+v", res)
+	}
+	if res := post(t, judge, []byte("subm")); res.Msg != "ok" {
+		t.Fatalf("judge failed: %+v", res)
+	}
+
+	select {
+	case c := <-callbacks:
+		if c.query.Get("sid") != "3" || c.query.Get("mode") != "tests" {
+			t.Errorf("unexpected callback %v", c.query)
+		}
+		if c.signature != judger.signature(3, "tests", c.body) {
+			t.Errorf("invalid signature %q", c.signature)
+		}
+		var result Result
+		if err := json.Unmarshal(c.body, &result); err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Subtask) != 1 || len(result.Subtask[0].Testcase) != 2 || result.Subtask[0].Testcase[0].Score != 50 {
+			t.Errorf("unexpected result %s", c.body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no callback")
+	}
+	if calls := judger.Calls(); len(calls) != 1 || calls[0].Sid != 3 || string(calls[0].Content) != "subm" {
+		t.Errorf("unexpected calls %+v", calls)
+	}
+}
+
+func TestFailures(t *testing.T) {
+	judger := New("")
+	judger.Plan = func(call Call) Plan {
+		return Plan{Failure: Refuse}
+	}
+	server := httptest.NewServer(judger)
+	defer server.Close()
+
+	cb := "http://localhost:1/FinishJudging?sid=1"
+	if res := post(t, server.URL+"/custom?"+url.Values{"cb": {cb}}.Encode(), nil); res.Msg == "ok" {
+		t.Errorf("expected to be refused, got %+v", res)
+	}
+	judger.SetDown(true)
+	res, err := http.Get(server.URL + "/log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		t.Error("expected /log to fail when down")
+	}
+}
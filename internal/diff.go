@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lines of context around changes in unified diffs
+const diffContext = 3
+
+/*
+Files with more changed lines or larger edit distances are only reported to
+differ. Memory of the Myers algorithm grows with the square of the edit
+distance.
+*/
+const (
+	diffMaxLines = 20000
+	diffMaxEdits = 1000
+)
+
+type diffOp struct {
+	kind byte //' ', '-' or '+'
+	line string
+	//lines of a and b before this line
+	ai, bi int
+}
+
+func diffSplit(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+/*
+Line-based diff of a and b in the unified format, with names of files in the
+header. Returns an empty string if they are the same, or a line saying they
+differ if they are too different to diff.
+*/
+func UnifiedDiff(name_a, name_b string, a, b string) string {
+	ops, ok := diffLines(diffSplit(a), diffSplit(b))
+	if !ok {
+		return fmt.Sprintf("Files %s and %s differ\n", name_a, name_b)
+	}
+	changes := []int{}
+	for i, op := range ops {
+		if op.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+	var ret strings.Builder
+	fmt.Fprintf(&ret, "--- %s\n+++ %s\n", name_a, name_b)
+	for i := 0; i < len(changes); {
+		//changes whose gaps are at most 2*diffContext lines are in the same hunk
+		j := i
+		for j+1 < len(changes) && changes[j+1]-changes[j] <= 2*diffContext+1 {
+			j++
+		}
+		start := changes[i] - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := changes[j] + diffContext + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+		len_a, len_b := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				len_a++
+			}
+			if op.kind != '-' {
+				len_b++
+			}
+		}
+		start_a, start_b := ops[start].ai, ops[start].bi
+		if len_a > 0 {
+			start_a++
+		}
+		if len_b > 0 {
+			start_b++
+		}
+		fmt.Fprintf(&ret, "@@ -%d,%d +%d,%d @@\n", start_a, len_a, start_b, len_b)
+		for _, op := range ops[start:end] {
+			ret.WriteByte(op.kind)
+			ret.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				ret.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = j + 1
+	}
+	return ret.String()
+}
+
+// Edit script from a to b with the Myers algorithm, returns false if they are too different
+func diffLines(a, b []string) ([]diffOp, bool) {
+	//common prefix and suffix are trimmed first
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	if len(a)+len(b)-2*(pre+suf) > diffMaxLines {
+		return nil, false
+	}
+	mid, ok := diffMyers(a[pre:len(a)-suf], b[pre:len(b)-suf])
+	if !ok {
+		return nil, false
+	}
+	ops := []diffOp{}
+	for i := 0; i < pre; i++ {
+		ops = append(ops, diffOp{' ', a[i], i, i})
+	}
+	for _, op := range mid {
+		op.ai += pre
+		op.bi += pre
+		ops = append(ops, op)
+	}
+	for i := 0; i < suf; i++ {
+		ai, bi := len(a)-suf+i, len(b)-suf+i
+		ops = append(ops, diffOp{' ', a[ai], ai, bi})
+	}
+	return ops, true
+}
+
+func diffMyers(a, b []string) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	max := n + m
+	v := make([]int, 2*max+2)
+	//trace[d] is v[-(d-1)..d-1] before the d-th step
+	trace := [][]int{}
+	d := 0
+	for ; d <= max; d++ {
+		if d > diffMaxEdits {
+			return nil, false
+		}
+		if d == 0 {
+			trace = append(trace, nil)
+		} else {
+			trace = append(trace, append([]int{}, v[max-d+1:max+d]...))
+		}
+		found := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+		if found {
+			break
+		}
+	}
+	//backtrack from the end, ops are in reverse order
+	rev := []diffOp{}
+	x, y := n, m
+	for ; d > 0; d-- {
+		prev := trace[d]
+		get := func(k int) int { return prev[k+d-1] }
+		k := x - y
+		var prev_k int
+		if k == -d || (k != d && get(k-1) < get(k+1)) {
+			prev_k = k + 1
+		} else {
+			prev_k = k - 1
+		}
+		prev_x := get(prev_k)
+		prev_y := prev_x - prev_k
+		for x > prev_x && y > prev_y {
+			x--
+			y--
+			rev = append(rev, diffOp{' ', a[x], x, y})
+		}
+		if x == prev_x {
+			y--
+			rev = append(rev, diffOp{'+', b[y], x, y})
+		} else {
+			x--
+			rev = append(rev, diffOp{'-', a[x], x, y})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		rev = append(rev, diffOp{' ', a[x], x, y})
+	}
+	ops := make([]diffOp, len(rev))
+	for i := range rev {
+		ops[i] = rev[len(rev)-1-i]
+	}
+	return ops, true
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(n int, format string) string {
+		ret := strings.Builder{}
+		for i := 0; i < n; i++ {
+			ret.WriteString(strings.Replace(format, "#", string(rune('a'+i%26)), 1) + "\n")
+		}
+		return ret.String()
+	}
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"identical", "a\nb\n", "a\nb\n", ""},
+		{"both empty", "", "", ""},
+		{"from empty", "", "x\ny\n", "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n"},
+		{"to empty", "x\n", "", "--- a\n+++ b\n@@ -1,1 +0,0 @@\n-x\n"},
+		{"insert only", "a\nb\n", "a\nx\nb\n", "--- a\n+++ b\n@@ -1,2 +1,3 @@\n a\n+x\n b\n"},
+		{"delete only", "a\nb\nc\n", "a\nc\n", "--- a\n+++ b\n@@ -1,3 +1,2 @@\n a\n-b\n c\n"},
+		{"replace", "a\nb\nc\n", "a\nx\nc\n", "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"no newline", "a\n", "a", "--- a\n+++ b\n@@ -1,1 +1,1 @@\n-a\n+a\n\\ No newline at end of file\n"},
+		{
+			"separate hunks",
+			lines(20, "#"),
+			strings.Replace(strings.Replace(lines(20, "#"), "b\n", "B\n", 1), "s\n", "S\n", 1),
+			"--- a\n+++ b\n@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n@@ -16,5 +16,5 @@\n p\n q\n r\n-s\n+S\n t\n",
+		},
+		{"too many lines", lines(diffMaxLines, "a#"), lines(diffMaxLines, "b#"), "Files a and b differ\n"},
+		{"too many edits", lines(diffMaxEdits, "a#"), lines(diffMaxEdits, "b#"), "Files a and b differ\n"},
+	}
+	for _, test := range tests {
+		if got := UnifiedDiff("a", "b", test.a, test.b); got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDiffLinesEdits(t *testing.T) {
+	//every edit script turns a into b
+	tests := [][2]string{
+		{"abcabba", "cbabac"},
+		{"", "abc"},
+		{"abc", ""},
+		{"aaaa", "aa"},
+		{"xaxbx", "abxx"},
+	}
+	for _, test := range tests {
+		a, b := strings.Split(test[0], ""), strings.Split(test[1], "")
+		ops, ok := diffLines(a, b)
+		if !ok {
+			t.Fatalf("%q -> %q: too different", test[0], test[1])
+		}
+		got_a, got_b := "", ""
+		for _, op := range ops {
+			if op.kind != '+' {
+				got_a += op.line
+			}
+			if op.kind != '-' {
+				got_b += op.line
+			}
+		}
+		if got_a != test[0] || got_b != test[1] {
+			t.Errorf("%q -> %q: edit script gives %q -> %q", test[0], test[1], got_a, got_b)
+		}
+	}
+}
@@ -24,7 +24,6 @@ var (
 //此函数使用 reflect 确保 register 给定的输入与 listen 的参数一致
 func Register(mode string, in ...any) {
 	hookLock.Lock()
-	defer hookLock.Unlock()
 	value, ok := hooks[mode]
 	if !ok {
 		value = &hookValue{in: nil, callee: []any{}}
@@ -41,23 +40,27 @@ func Register(mode string, in ...any) {
 			check = false
 		} else {
 			for key := range in {
-				if in[key] != value.in[key] {
+				if reflect.TypeOf(in[key]) != value.in[key] {
 					check = false
 					break
 				}
 			}
 		}
 		if (!check) {
+			hookLock.Unlock()
 			fmt.Println("error on hook registration: in type error")
 			return
 		}
 	}
+	//复制一份监听函数后释放锁，这样监听函数中也可以注册钩子
+	callee := append([]any{}, value.callee...)
+	hookLock.Unlock()
 	//run callees
 	args := make([]reflect.Value, len(in))
 	for key := range in {
 		args[key] = reflect.ValueOf(in[key])
 	}
-	for _, f := range value.callee {
+	for _, f := range callee {
 		reflect.ValueOf(f).Call(args)
 	}
 }
@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHookRegisterTwice(t *testing.T) {
+	sum := 0
+	Listen("TestHookTwice", func(a int) { sum += a })
+	Register("TestHookTwice", 1)
+	Register("TestHookTwice", 2)
+	if sum != 3 {
+		t.Fatalf("listener got sum %d, want 3", sum)
+	}
+	//mismatched arguments are rejected
+	Register("TestHookTwice", "3")
+	if sum != 3 {
+		t.Fatalf("listener ran with mismatched arguments, sum %d", sum)
+	}
+}
+
+func TestHookRegisterInListener(t *testing.T) {
+	inner := 0
+	Listen("TestHookOuter", func(a int) { Register("TestHookInner", a) })
+	Listen("TestHookInner", func(a int) { inner += a })
+	done := make(chan struct{})
+	go func() {
+		Register("TestHookOuter", 1)
+		Register("TestHookOuter", 1)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("registering a hook in a listener deadlocks")
+	}
+	if inner != 2 {
+		t.Fatalf("inner listener ran %d times, want 2", inner)
+	}
+}
@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"testing"
+	"yao/db"
+	"yao/fakejudger"
+)
+
+// A submission goes through the queue, dispatching, the callback and SubmUpdate
+func TestJudgeFlow(t *testing.T) {
+	testDB(t)
+	testQueue(t)
+	pid, sum := testProblem(t, "pretest", "tests")
+	_, fake := testJudger(t, 1, func(call fakejudger.Call) fakejudger.Plan {
+		return fakejudger.Plan{Result: fakejudger.Accepted(2, 100)}
+	}, sum)
+	sub := testSubmission(t, 1, pid, 0)
+	var uuid int64
+	err := db.SelectSingleColumn(&uuid, "select uuid from submissions where submission_id=?", sub.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SubmJudge(sub, false, uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testWait(t, "the submission to be judged", func() bool { return testSubmStatus(t, sub.Id) == Finished })
+
+	subs := SubmListByIds([]int{sub.Id})
+	if len(subs) != 1 {
+		t.Fatalf("submission %d not found", sub.Id)
+	}
+	got := subs[0]
+	if got.Score != 100 || got.Accepted != Accepted || got.Verdict != VerdictAC || got.SampleScore != 100 {
+		t.Errorf("score %v, sample score %v, accepted %d, verdict %q, want all accepted", got.Score, got.SampleScore, got.Accepted, got.Verdict)
+	}
+	var result string
+	err = db.SelectSingleColumn(&result, "select result from submission_details where submission_id=?", sub.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := JudgeResultParse([]byte(result))
+	if err != nil || len(res.Subtask) != 1 || len(res.Subtask[0].Testcase) != 2 {
+		t.Errorf("unexpected result %s: %v", result, err)
+	}
+	testWait(t, "the queue to be empty", func() bool { return len(waitingList.List()) == 0 })
+	//there's no extra data, so the judger isn't asked for it
+	calls := fake.Calls()
+	if len(calls) != 2 || calls[0].Mode != "pretest" || calls[1].Mode != "tests" || calls[1].Sid != sub.Id || calls[1].CheckSum != sum {
+		t.Errorf("unexpected calls %+v", calls)
+	}
+}
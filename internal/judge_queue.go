@@ -0,0 +1,557 @@
+package internal
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"yao/config"
+	"yao/db"
+
+	utils "github.com/super-yaoj/yaoj-utils"
+)
+
+/*
+Judging queue persisted in table judge_queue.
+
+Rows are inserted when entries are pushed and deleted only when entries are
+done, so entries being judged are also resumed after restarts.
+
+Entries with higher priority are popped first. Entries with the same priority
+are scheduled fairly among submitters by start-time fair queueing: each entry
+is tagged with one plus the larger of the band's virtual time (tag of the last
+popped entry) and the submitter's last tag in the band, and entries with
+smaller tags are popped first. So a submitter pushing many entries at once
+only gets one entry judged per round.
+
+Pending entries gain judgeAgingStep priority each time they have waited
+config.Global.JudgeAging seconds, so entries of low priority are judged
+eventually.
+*/
+type judgeQueue struct {
+	lock sync.Mutex
+	list judgeHeap
+	//entries popped but not done yet, indexed by row id
+	inflight map[int64]*JudgeEntry
+	//closed and renewed on each push to wake up waiting poppers
+	pushed chan struct{}
+	//virtual time of each priority band
+	vtime map[int]int64
+	//last tag given to each submitter in each priority band
+	last map[judgeBand]int64
+}
+
+type judgeBand struct {
+	priority  int
+	submitter int
+}
+
+// priority gained by pending entries each time they age
+const judgeAgingStep = 10
+
+type judgeHeap []*JudgeEntry
+
+func (h judgeHeap) Len() int { return len(h) }
+func (h judgeHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	if h[i].tag != h[j].tag {
+		return h[i].tag < h[j].tag
+	}
+	return h[i].id < h[j].id
+}
+func (h judgeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+// index of the first entry satisfying can, -1 if there's none
+func (h judgeHeap) first(can func(*JudgeEntry) bool) int {
+	ret := -1
+	for i := range h {
+		if (can == nil || can(h[i])) && (ret < 0 || h.Less(i, ret)) {
+			ret = i
+		}
+	}
+	return ret
+}
+func (h judgeHeap) find(id int64) int {
+	for i := range h {
+		if h[i].id == id {
+			return i
+		}
+	}
+	return -1
+}
+func (h *judgeHeap) Push(x any) { *h = append(*h, x.(*JudgeEntry)) }
+func (h *judgeHeap) Pop() any {
+	old := *h
+	ret := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return ret
+}
+
+type judgeQueueRow struct {
+	Id          int64     `db:"id"`
+	Sid         int       `db:"submission_id"`
+	Mode        string    `db:"mode"`
+	Uuid        int64     `db:"uuid"`
+	Submitter   int       `db:"submitter"`
+	Priority    int       `db:"priority"`
+	EnqueueTime time.Time `db:"enqueue_time"`
+}
+
+func newJudgeQueue() *judgeQueue {
+	return &judgeQueue{
+		inflight: make(map[int64]*JudgeEntry),
+		pushed:   make(chan struct{}),
+		vtime:    make(map[int]int64),
+		last:     make(map[judgeBand]int64),
+	}
+}
+
+// Give the entry a fair queueing tag in its priority band.
+// You should hold the lock before calling this function.
+func (q *judgeQueue) tag(entry *JudgeEntry) {
+	band := judgeBand{entry.priority, entry.submitter}
+	tag := q.vtime[entry.priority]
+	if last := q.last[band]; last > tag {
+		tag = last
+	}
+	entry.tag = tag + 1
+	q.last[band] = entry.tag
+}
+
+// Push an entry into the queue. Entries given back to the queue keep their rows.
+func (q *judgeQueue) Push(entry *JudgeEntry) error {
+	if entry.id == 0 {
+		needs, err := judgeNeedsOf(entry)
+		if err != nil {
+			return err
+		}
+		entry.needs = needs
+		entry.enqueueTime = time.Now()
+		entry.agedTime = entry.enqueueTime
+		id, err := db.InsertGetId("insert into judge_queue values (null, ?, ?, ?, ?, ?, ?)", entry.sid, entry.mode, entry.uuid, entry.submitter, entry.priority, entry.enqueueTime)
+		if err != nil {
+			return err
+		}
+		entry.id = id
+	}
+	q.push(entry)
+	return nil
+}
+
+func (q *judgeQueue) push(entry *JudgeEntry) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.inflight, entry.id)
+	entry.judger = 0
+	//entries given back keep their tags so they are not delayed
+	if entry.tag == 0 {
+		q.tag(entry)
+	}
+	heap.Push(&q.list, entry)
+	close(q.pushed)
+	q.pushed = make(chan struct{})
+}
+
+/*
+Block until there is an entry satisfying can in the queue, the entry is
+assigned to judger. A nil can accepts all entries. Returns false if stop is
+closed before that.
+*/
+func (q *judgeQueue) Pop(judger int, can func(*JudgeEntry) bool, stop <-chan struct{}) (*JudgeEntry, bool) {
+	return q.pop(judger, can, nil, stop)
+}
+
+// Block until there is an entry satisfying can in the queue or timeout fires, a nil timeout never fires.
+func (q *judgeQueue) PopTimeout(judger int, can func(*JudgeEntry) bool, timeout <-chan time.Time) (*JudgeEntry, bool) {
+	return q.pop(judger, can, timeout, nil)
+}
+
+func (q *judgeQueue) pop(judger int, can func(*JudgeEntry) bool, timeout <-chan time.Time, stop <-chan struct{}) (*JudgeEntry, bool) {
+	for {
+		q.lock.Lock()
+		if i := q.list.first(can); i >= 0 {
+			ret := heap.Remove(&q.list, i).(*JudgeEntry)
+			if ret.tag > q.vtime[ret.priority] {
+				q.vtime[ret.priority] = ret.tag
+			}
+			ret.judger = judger
+			ret.startTime = time.Now()
+			q.inflight[ret.id] = ret
+			q.lock.Unlock()
+			return ret, true
+		}
+		pushed := q.pushed
+		q.lock.Unlock()
+		select {
+		case <-pushed:
+		case <-timeout:
+			return nil, false
+		case <-stop:
+			return nil, false
+		}
+	}
+}
+
+// Remove a finished entry from the database
+func (q *judgeQueue) Done(entry *JudgeEntry) error {
+	q.lock.Lock()
+	delete(q.inflight, entry.id)
+	q.prune()
+	q.lock.Unlock()
+	_, err := db.Exec("delete from judge_queue where id=?", entry.id)
+	return err
+}
+
+// Remove a pending entry from the queue, the entry is returned without being done
+func (q *judgeQueue) Remove(id int64) (*JudgeEntry, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	i := q.list.find(id)
+	if i < 0 {
+		return nil, q.notPending(id)
+	}
+	ret := heap.Remove(&q.list, i).(*JudgeEntry)
+	q.prune()
+	return ret, nil
+}
+
+// Change the priority of a pending entry
+func (q *judgeQueue) SetPriority(id int64, priority int) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	i := q.list.find(id)
+	if i < 0 {
+		return q.notPending(id)
+	}
+	_, err := db.Exec("update judge_queue set priority=? where id=?", priority, id)
+	if err != nil {
+		return err
+	}
+	q.list[i].priority = priority
+	q.tag(q.list[i])
+	heap.Fix(&q.list, i)
+	q.prune()
+	return nil
+}
+
+/*
+Forget virtual times of bands without pending or in-flight entries, they start
+over when entries come again.
+You should hold the lock before calling this function.
+*/
+func (q *judgeQueue) prune() {
+	live := make(map[judgeBand]bool)
+	for _, entry := range q.list {
+		live[judgeBand{entry.priority, entry.submitter}] = true
+	}
+	for _, entry := range q.inflight {
+		live[judgeBand{entry.priority, entry.submitter}] = true
+	}
+	priorities := make(map[int]bool)
+	for band := range live {
+		priorities[band.priority] = true
+	}
+	for band := range q.last {
+		if !live[band] {
+			delete(q.last, band)
+		}
+	}
+	for priority := range q.vtime {
+		if !priorities[priority] {
+			delete(q.vtime, priority)
+		}
+	}
+}
+
+// Raise the priority of entries which have waited long enough
+func (q *judgeQueue) age(interval time.Duration) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	now := time.Now()
+	aged := judgeHeap{}
+	for _, entry := range q.list {
+		if now.Sub(entry.agedTime) >= interval {
+			aged = append(aged, entry)
+		}
+	}
+	if len(aged) == 0 {
+		return nil
+	}
+	//tag earlier entries first so they keep their order in the new band
+	sort.Slice(aged, aged.Less)
+	ids := make([]int64, len(aged))
+	for i, entry := range aged {
+		ids[i] = entry.id
+	}
+	//the database is updated first like SetPriority, so priorities in memory never run ahead of it
+	_, err := db.Exec("update judge_queue set priority=priority+? where id in ("+utils.JoinArray(ids)+")", judgeAgingStep)
+	if err != nil {
+		return err
+	}
+	for _, entry := range aged {
+		entry.priority += judgeAgingStep
+		entry.agedTime = now
+		q.tag(entry)
+	}
+	heap.Init(&q.list)
+	q.prune()
+	return nil
+}
+
+func judgeAgingStart() {
+	if config.Global.JudgeAging <= 0 {
+		return
+	}
+	interval := time.Duration(config.Global.JudgeAging) * time.Second
+	ticker := time.NewTicker(interval / 10)
+	for range ticker.C {
+		err := waitingList.age(interval)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// You should hold the lock before calling this function.
+func (q *judgeQueue) notPending(id int64) error {
+	if _, ok := q.inflight[id]; ok {
+		return fmt.Errorf("entry %d is being judged", id)
+	}
+	return fmt.Errorf("no such entry: %d", id)
+}
+
+// Copies of pending and in-flight entries, in-flight ones first
+func (q *judgeQueue) List() []JudgeEntry {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	ret := make([]JudgeEntry, 0, len(q.inflight)+q.list.Len())
+	for _, entry := range q.inflight {
+		ret = append(ret, *entry)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].id < ret[j].id })
+	pending := make(judgeHeap, q.list.Len())
+	copy(pending, q.list)
+	sort.Slice(pending, pending.Less)
+	for _, entry := range pending {
+		ret = append(ret, *entry)
+	}
+	return ret
+}
+
+// Load entries saved in the database, returns submission ids having entries in the queue
+func (q *judgeQueue) Load() (map[int]bool, error) {
+	var rows []judgeQueueRow
+	err := db.SelectAll(&rows, "select * from judge_queue order by id")
+	if err != nil {
+		return nil, err
+	}
+	sids := make(map[int]bool)
+	for _, row := range rows {
+		entry := &JudgeEntry{
+			id:          row.Id,
+			sid:         row.Sid,
+			mode:        row.Mode,
+			uuid:        row.Uuid,
+			submitter:   row.Submitter,
+			priority:    row.Priority,
+			enqueueTime: row.EnqueueTime,
+			agedTime:    time.Now(),
+		}
+		entry.needs, err = judgeNeedsOf(entry)
+		if err != nil {
+			fmt.Println(err)
+		}
+		q.push(entry)
+		if row.Mode != "custom_test" {
+			sids[row.Sid] = true
+		}
+	}
+	return sids, nil
+}
+
+type JudgeQueueItem struct {
+	Id          int64     `json:"id"`
+	Sid         int       `json:"submission_id"`
+	Mode        string    `json:"mode"`
+	Priority    int       `json:"priority"`
+	EnqueueTime time.Time `json:"enqueue_time"`
+	//id of the judger judging the entry, 0 if it's pending
+	Judger int `json:"judger_id"`
+	//the submission has been rejudged after the entry is pushed, so the entry will be skipped
+	Superseded bool `json:"superseded"`
+}
+
+// Current uuids of submissions having entries, an entry is superseded if its uuid differs
+func judgeQueueUuids(entries []JudgeEntry) (map[int]int64, error) {
+	sids := []int{}
+	for _, entry := range entries {
+		if entry.mode != "custom_test" {
+			sids = append(sids, entry.sid)
+		}
+	}
+	uuids := make(map[int]int64)
+	if len(sids) == 0 {
+		return uuids, nil
+	}
+	var subs []struct {
+		Id   int   `db:"submission_id"`
+		Uuid int64 `db:"uuid"`
+	}
+	err := db.SelectAll(&subs, "select submission_id, uuid from submissions where submission_id in ("+utils.JoinArray(sids)+")")
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subs {
+		uuids[sub.Id] = sub.Uuid
+	}
+	return uuids, nil
+}
+
+// List pending and in-flight entries of the judging queue
+func JudgeQueueList() ([]JudgeQueueItem, error) {
+	entries := waitingList.List()
+	uuids, err := judgeQueueUuids(entries)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]JudgeQueueItem, len(entries))
+	for i, entry := range entries {
+		ret[i] = JudgeQueueItem{entry.id, entry.sid, entry.mode, entry.priority, entry.enqueueTime, entry.judger, false}
+		if entry.mode != "custom_test" {
+			ret[i].Superseded = uuids[entry.sid] != entry.uuid
+		}
+	}
+	return ret, nil
+}
+
+func JudgeQueueSetPriority(id int64, priority int) error {
+	return waitingList.SetPriority(id, priority)
+}
+
+// Cancel a pending entry, it's finished as an internal error unless it has been superseded
+func JudgeQueueCancel(id int64) error {
+	entry, err := waitingList.Remove(id)
+	if err != nil {
+		return err
+	}
+	judgeAbort(entry, entry.mode+": cancelled by admin")
+	return nil
+}
+
+type JudgeQueuePosition struct {
+	Mode string `json:"mode"`
+	//1 for the next entry to be judged
+	Position int `json:"position"`
+	//null if there's no judger or no judging history
+	EstimatedStart *time.Time `json:"estimated_start"`
+}
+
+/*
+Positions of pending entries of a submission in the judging queue. Only entries
+of submissions are counted, custom tests and superseded entries are left out.
+*/
+func JudgeQueuePositions(sid int, uuid int64) []JudgeQueuePosition {
+	entries := waitingList.List()
+	ret := []JudgeQueuePosition{}
+	uuids, err := judgeQueueUuids(entries)
+	if err != nil {
+		fmt.Println(err)
+		return ret
+	}
+	pending := 0
+	for _, entry := range entries {
+		if entry.judger != 0 || entry.mode == "custom_test" || uuids[entry.sid] != entry.uuid {
+			continue
+		}
+		pending++
+		if entry.sid == sid && entry.uuid == uuid {
+			ret = append(ret, JudgeQueuePosition{Mode: entry.mode, Position: pending})
+		}
+	}
+	if len(ret) == 0 {
+		return ret
+	}
+	starts := judgeEstimate(entries, ret[len(ret)-1].Position)
+	if starts != nil {
+		for i := range ret {
+			ret[i].EstimatedStart = &starts[ret[i].Position-1]
+		}
+	}
+	return ret
+}
+
+/*
+Estimate start times of the first n pending entries by letting slots of
+accepting judgers take entries in order, each entry takes the average duration
+of the judger's recent tasks. Judgers without history take the average of all
+judgers. Returns nil if there's nothing to estimate with.
+*/
+func judgeEstimate(entries []JudgeEntry, n int) []time.Time {
+	judgerLock.RLock()
+	//average durations of each slot, and slots of each judger
+	var avgs []time.Duration
+	slots := make(map[int][]int)
+	var sum, cnt time.Duration
+	for _, judger := range judgers {
+		if !judger.Healthy || judger.Draining || judger.Stopping {
+			continue
+		}
+		avg := judger.avgDuration()
+		for i := 0; i < judger.Slots; i++ {
+			slots[judger.Id] = append(slots[judger.Id], len(avgs))
+			avgs = append(avgs, avg)
+		}
+		if avg > 0 {
+			sum += avg
+			cnt++
+		}
+	}
+	judgerLock.RUnlock()
+	if cnt == 0 {
+		return nil
+	}
+	now := time.Now()
+	free := make([]time.Time, len(avgs))
+	all := make([]int, len(avgs))
+	for i := range avgs {
+		if avgs[i] == 0 {
+			avgs[i] = sum / cnt
+		}
+		free[i] = now
+		all[i] = i
+	}
+	//slots busy with in-flight entries are free after their average durations
+	for _, entry := range entries {
+		if ids, ok := slots[entry.judger]; ok {
+			i := judgeEarliest(free, ids)
+			start := entry.startTime
+			if free[i].After(start) {
+				start = free[i]
+			}
+			if end := start.Add(avgs[i]); end.After(free[i]) {
+				free[i] = end
+			}
+		}
+	}
+	ret := make([]time.Time, n)
+	for k := 0; k < n; k++ {
+		i := judgeEarliest(free, all)
+		ret[k] = free[i]
+		free[i] = free[i].Add(avgs[i])
+	}
+	return ret
+}
+
+// the slot among ids which is free first
+func judgeEarliest(free []time.Time, ids []int) int {
+	ret := ids[0]
+	for _, i := range ids {
+		if free[i].Before(free[ret]) {
+			ret = i
+		}
+	}
+	return ret
+}
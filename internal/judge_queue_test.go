@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"testing"
+	"yao/db"
+)
+
+func TestJudgeQueuePrune(t *testing.T) {
+	q := newJudgeQueue()
+	q.push(&JudgeEntry{id: 1, sid: 1, priority: 10, submitter: 1})
+	q.push(&JudgeEntry{id: 2, sid: 2, priority: 20, submitter: 2})
+	popped, ok := q.PopTimeout(1, nil, nil)
+	if !ok || popped.id != 2 {
+		t.Fatalf("popped %+v, want entry 2", popped)
+	}
+	//the band of an in-flight entry is kept, so the entry keeps its place when it's given back
+	if _, err := q.Remove(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := q.vtime[10]; ok {
+		t.Error("virtual time of an empty band is kept")
+	}
+	if _, ok := q.last[judgeBand{10, 1}]; ok {
+		t.Error("last tag of an empty band is kept")
+	}
+	if _, ok := q.vtime[20]; !ok {
+		t.Error("virtual time of the band of an in-flight entry is dropped")
+	}
+}
+
+func TestJudgeQueuePositions(t *testing.T) {
+	testDB(t)
+	testQueue(t)
+	pid, _ := testProblem(t, "tests")
+	old, sub := testSubmission(t, 1, pid, 0), testSubmission(t, 2, pid, 0)
+	var uuid int64
+	err := db.SelectSingleColumn(&uuid, "select uuid from submissions where submission_id=?", sub.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a custom test and a superseded entry are ahead of the submission
+	waitingList.push(&JudgeEntry{id: -1, sid: 1, mode: "custom_test", priority: 165, submitter: 3})
+	waitingList.push(&JudgeEntry{id: -2, sid: old.Id, mode: "tests", uuid: -1, priority: 60, submitter: 1})
+	waitingList.push(&JudgeEntry{id: -3, sid: sub.Id, mode: "tests", uuid: uuid, priority: 60, submitter: 2})
+	positions := JudgeQueuePositions(sub.Id, uuid)
+	if len(positions) != 1 || positions[0].Position != 1 {
+		t.Fatalf("positions %+v, want the first", positions)
+	}
+}
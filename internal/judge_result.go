@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/super-yaoj/yaoj-core/pkg/problem"
+	utils "github.com/super-yaoj/yaoj-utils"
+)
+
+// A file shown in the result of a testcase, e.g. the input or the output of the checker
+type ResultFile struct {
+	Title   string
+	Content string
+}
+
+type TestResult struct {
+	Title  string  //verdict, e.g. "Accepted"
+	Score  float64 //score of the testcase
+	Time   float64 //in nanoseconds
+	Memory float64 //in bytes
+	File   []ResultFile
+}
+
+type SubtaskResult struct {
+	Fullscore float64
+	Testcase  []TestResult
+}
+
+// Result of judging a submission, in the format judgers report to FinishJudging
+type JudgeResult struct {
+	IsSubtask bool
+	Subtask   []SubtaskResult
+}
+
+/*
+Parse and validate a result reported by judgers. Scores, time and memory
+should be finite and non-negative, and subtasks shouldn't be missing.
+*/
+func JudgeResultParse(data []byte) (*JudgeResult, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty result")
+	}
+	var ret JudgeResult
+	err := jsoniter.Unmarshal(data, &ret)
+	if err != nil {
+		return nil, err
+	}
+	if ret.Subtask == nil {
+		return nil, errors.New("missing subtasks")
+	}
+	invalid := func(val float64) bool {
+		return math.IsNaN(val) || math.IsInf(val, 0) || val < 0
+	}
+	for i, subtask := range ret.Subtask {
+		if invalid(subtask.Fullscore) {
+			return nil, fmt.Errorf("subtask %d: invalid fullscore %v", i+1, subtask.Fullscore)
+		}
+		for j, test := range subtask.Testcase {
+			if invalid(test.Score) || invalid(test.Time) || invalid(test.Memory) {
+				return nil, fmt.Errorf("subtask %d testcase %d: invalid score, time or memory", i+1, j+1)
+			}
+		}
+	}
+	return &ret, nil
+}
+
+// Score, total time and max memory of the result, and whether all subtasks get full scores.
+func (res *JudgeResult) Summary(testdata *problem.TestdataInfo) (score float64, time float64, memory float64, accepted bool) {
+	accepted = true
+	for _, subtask := range res.Subtask {
+		var sub_score float64
+		for i, test := range subtask.Testcase {
+			time += test.Time
+			memory = utils.Max(memory, test.Memory)
+			if i == 0 {
+				sub_score = test.Score
+				continue
+			}
+			if res.IsSubtask {
+				switch testdata.CalcMethod {
+				case problem.Mmin:
+					sub_score = utils.Min(sub_score, test.Score)
+				case problem.Mmax:
+					sub_score = utils.Max(sub_score, test.Score)
+				case problem.Msum:
+					sub_score += test.Score
+				}
+			} else {
+				sub_score += test.Score
+			}
+		}
+		score += sub_score
+		if sub_score != subtask.Fullscore {
+			accepted = false
+		}
+	}
+	return
+}
@@ -2,47 +2,112 @@ package internal
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 	"yao/config"
 	"yao/db"
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/super-yaoj/yaoj-core/pkg/utils"
-	"github.com/super-yaoj/yaoj-utils/pq"
 )
 
 type Judger struct {
-	url string
-	//unique random judger id, change after each request
-	jid      string
-	callback chan []byte
+	Id int `json:"judger_id"`
+	//url of push judgers, or name of pull judgers
+	Url string `json:"url"`
+	//whether the judger leases entries by itself, see JudgerLease
+	Pull bool `json:"pull"`
+	//true if the judger is removed but hasn't finished its current tasks yet
+	Stopping bool `json:"stopping"`
+	//number of tasks the judger runs concurrently
+	Slots int `json:"slots"`
+	//number of tasks in progress
+	Running int `json:"running"`
+	//true if the judger missed the deadline of its last task
+	Suspect bool `json:"suspect"`
+	//drained judgers finish their current tasks but don't take new entries
+	Draining bool `json:"draining"`
+	//unhealthy judgers are taken out of the dispatch loop until they recover
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"last_check"`
+	//only entries the judger is able to judge are given to it
+	Caps JudgerCaps `json:"capabilities"`
+	//closed when the judger is removed from the registry
+	stop chan struct{}
+	//up is closed while the judger is accepting entries, down is closed while it isn't healthy
+	up, down chan struct{}
+	//durations of the most recent tasks, used to estimate waiting time
+	durations []time.Duration
+	//check sums of problem data synced to the judger
+	synced map[string]bool
+	//data is synced to the judger one problem at a time
+	syncLock *sync.Mutex
 }
 
 type JudgeEntry struct {
-	sid      int
-	mode     string       //one of "pretest", "tests", "extra", "custom_test"
-	uuid     int64        //if mode != "custom_test"(i.e. normal submission), uuid means whether this submission is the recent entry in the judging queue(set by time-stamp)
-	callback *chan []byte //if mode="custom_test", you should give a callback channel which returns the result
+	id          int64 //row id in table judge_queue
+	sid         int
+	mode        string     //one of "pretest", "tests", "extra", "custom_test"
+	uuid        int64      //if mode != "custom_test"(i.e. normal submission), uuid means whether this submission is the recent entry in the judging queue(set by time-stamp)
+	submitter   int        //entries of the same priority are scheduled fairly among submitters
+	priority    int        //priority in the judging queue, used when the entry is given back to the queue
+	tag         int64      //fair queueing tag within the priority band, see judgeQueue
+	agedTime    time.Time  //last time the entry is enqueued or gains priority by aging
+	retries     int        //times the entry has been requeued because the judger missed the deadline
+	downs       int        //times the entry has been requeued because the judger went down
+	judger      int        //id of the judger judging the entry, 0 if it's pending
+	needs       judgeNeeds //what the judger should be able to do
+	enqueueTime time.Time
+	startTime   time.Time //time the entry is popped by a judger
 }
 
-func NewJudger(url string) *Judger {
-	return &Judger{url, utils.RandomString(64), make(chan []byte)}
+func NewJudger(id int, url string, slots int) *Judger {
+	if slots <= 0 {
+		slots = 1
+	}
+	judger := &Judger{
+		Id:       id,
+		Url:      url,
+		Slots:    slots,
+		Healthy:  true,
+		stop:     make(chan struct{}),
+		synced:   make(map[string]bool),
+		syncLock: &sync.Mutex{},
+		up:       make(chan struct{}),
+		down:     make(chan struct{}),
+	}
+	close(judger.up)
+	return judger
 }
 
-var judgers = []*Judger{
-	NewJudger("http://localhost:3000"),
-	// "http://localhost:8083",
-	// "http://localhost:8084",
+// whether the judger has been removed from the registry
+func (judger *Judger) stopped() bool {
+	select {
+	case <-judger.stop:
+		return true
+	default:
+		return false
+	}
 }
 
-var waitingList = pq.NewBlockPriorityQueue[*JudgeEntry]()
+var (
+	judgers      = make(map[int]*Judger)
+	judgerLock   = sync.RWMutex{}
+	judgerLastId = 0
+)
+
+var waitingList = newJudgeQueue()
 
 // 1 on each bit means that the corresponding status has finished
 const (
@@ -55,50 +120,479 @@ const (
 )
 
 func JudgersInit() {
+	queued, err := waitingList.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	//unfinished submissions which are not in the queue, e.g. submitted before the queue is persisted
 	var sub []Submission
-	err := db.SelectAll(&sub, "select submission_id, problem_id, contest_id, uuid from submissions where status < ? and status >= 0", Finished)
+	err = db.SelectAll(&sub, "select submission_id, problem_id, contest_id, submitter, uuid from submissions where status < ? and status >= 0", Finished)
 	if err != nil {
 		log.Fatal(err)
 	}
 	for _, val := range sub {
+		if queued[val.Id] {
+			continue
+		}
 		err := SubmJudge(val.SubmissionBase, true, val.Uuid)
 		if err != nil {
 			fmt.Println(err)
 		}
 	}
-	for _, i := range judgers {
-		go judgerStart(i)
+	for _, url := range config.Global.Judgers {
+		_, err := JudgerAdd(url, config.Global.JudgerSlots, JudgerCaps{})
+		if err != nil {
+			log.Printf("judger %s is not added: %v", url, err)
+		}
+	}
+	go judgerProbeStart()
+	go judgeAgingStart()
+	go customTestCleanStart()
+	go judgerPrefetchStart()
+	go judgerLogFollowStart()
+}
+
+/*
+Register a judger at runtime and start dispatching judge entries to it.
+Judgers are refused if config.Global.JudgerSecret is empty, since their results
+can't be checked.
+*/
+func JudgerAdd(url string, slots int, caps JudgerCaps) (*Judger, error) {
+	if config.Global.JudgerSecret == "" {
+		return nil, errJudgerSecret
+	}
+	judgerLock.Lock()
+	defer judgerLock.Unlock()
+	judgerLastId++
+	judger := NewJudger(judgerLastId, url, slots)
+	judger.Caps = caps
+	judgers[judger.Id] = judger
+	go judgerStart(judger)
+	return judger, nil
+}
+
+/*
+Deregister a judger. The judger finishes its current task, and the entry it
+takes from the queue afterwards is given back to the queue. It is kept in the
+registry until then so that the result of the current task can be received.
+*/
+func JudgerRemove(id int) error {
+	judgerLock.Lock()
+	defer judgerLock.Unlock()
+	judger, ok := judgers[id]
+	if !ok || judger.Stopping {
+		return fmt.Errorf("no such judger: id=%d", id)
+	}
+	judger.Stopping = true
+	close(judger.stop)
+	if judger.Pull {
+		//there's no dispatching goroutine, the judger leaves once its leases are finished
+		judgerPullRemoved[judger.Url] = true
+		if judger.Running == 0 {
+			delete(judgers, id)
+		}
+	}
+	return nil
+}
+
+func JudgerList() []Judger {
+	judgerLock.RLock()
+	defer judgerLock.RUnlock()
+	ret := make([]Judger, 0, len(judgers))
+	for _, judger := range judgers {
+		ret = append(ret, *judger)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Id < ret[j].Id })
+	return ret
+}
+
+func judgerAll() []*Judger {
+	judgerLock.RLock()
+	defer judgerLock.RUnlock()
+	ret := make([]*Judger, 0, len(judgers))
+	for _, judger := range judgers {
+		ret = append(ret, judger)
+	}
+	return ret
+}
+
+func (judger *Judger) isHealthy() bool {
+	judgerLock.RLock()
+	defer judgerLock.RUnlock()
+	return judger.Healthy
+}
+
+func (judger *Judger) accepting() bool {
+	judgerLock.RLock()
+	defer judgerLock.RUnlock()
+	return judger.Healthy && !judger.Draining
+}
+
+// Open or close judger.up according to whether the judger is accepting entries.
+// You should hold the writing lock before calling this function.
+func (judger *Judger) refreshUp() {
+	accepting := judger.Healthy && !judger.Draining
+	select {
+	case <-judger.up:
+		if !accepting {
+			judger.up = make(chan struct{})
+		}
+	default:
+		if accepting {
+			close(judger.up)
+		}
+	}
+}
+
+// Stop or resume giving new entries to a judger
+func JudgerDrain(id int, drain bool) error {
+	judgerLock.Lock()
+	defer judgerLock.Unlock()
+	judger, ok := judgers[id]
+	if !ok || judger.Stopping {
+		return fmt.Errorf("no such judger: id=%d", id)
+	}
+	judger.Draining = drain
+	judger.refreshUp()
+	return nil
+}
+
+// number of recent tasks kept for estimating waiting time
+const judgeHistory = 20
+
+// Record the duration of a successfully judged entry
+func (judger *Judger) judged(entry *JudgeEntry) {
+	duration := time.Since(entry.startTime)
+	judgerLogf(judger.Id, entry.sid, "%s of submission %d judged in %v", entry.mode, entry.sid, duration)
+	judgerLock.Lock()
+	defer judgerLock.Unlock()
+	judger.durations = append(judger.durations, duration)
+	if len(judger.durations) > judgeHistory {
+		judger.durations = judger.durations[1:]
 	}
 }
 
+// Average duration of recent tasks, 0 if there's no history.
+// You should hold the reading lock before calling this function.
+func (judger *Judger) avgDuration() time.Duration {
+	if len(judger.durations) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, d := range judger.durations {
+		sum += d
+	}
+	return sum / time.Duration(len(judger.durations))
+}
+
+// channel closed when the judger starts accepting entries
+func (judger *Judger) upChan() <-chan struct{} {
+	judgerLock.RLock()
+	defer judgerLock.RUnlock()
+	return judger.up
+}
+
+// channel closed when the judger becomes unhealthy
+func (judger *Judger) downChan() <-chan struct{} {
+	judgerLock.RLock()
+	defer judgerLock.RUnlock()
+	return judger.down
+}
+
+func (judger *Judger) setHealthy(healthy bool) {
+	judgerLock.Lock()
+	defer judgerLock.Unlock()
+	judger.LastCheck = time.Now()
+	if judger.Healthy == healthy {
+		return
+	}
+	judger.Healthy = healthy
+	if healthy {
+		log.Printf("judger %d (%s) is back online", judger.Id, judger.Url)
+		judger.down = make(chan struct{})
+	} else {
+		log.Printf("judger %d (%s) is down", judger.Id, judger.Url)
+		close(judger.down)
+	}
+	judger.refreshUp()
+}
+
+var probeClient = http.Client{Timeout: 5 * time.Second}
+
+func (judger *Judger) probe() {
+	if judger.Pull {
+		return
+	}
+	res, err := probeClient.Get(judger.Url + "/log")
+	if err != nil {
+		judger.setHealthy(false)
+		return
+	}
+	res.Body.Close()
+	judger.setHealthy(res.StatusCode == http.StatusOK)
+}
+
+// Check all judgers periodically, unhealthy ones stop taking entries until they recover.
+func judgerProbeStart() {
+	interval := time.Duration(config.Global.JudgerProbe) * time.Second
+	if interval <= 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		for _, judger := range judgerAll() {
+			go judger.probe()
+		}
+	}
+}
+
+func judgerGet(id int) (*Judger, bool) {
+	judgerLock.RLock()
+	defer judgerLock.RUnlock()
+	judger, ok := judgers[id]
+	return judger, ok
+}
+
+const (
+	//times an entry is requeued after its judger goes down, judgers failing
+	//by themselves are not the entry's fault, so it's higher than JudgeRetries
+	judgeDownRetries = 5
+	//the judger doesn't have the data of the problem
+	judgerErrNoData = 1
+	//times to sync the data again if the judger keeps losing it
+	judgerResyncs = 2
+)
+
 type judgerResponse struct {
 	Err      string `json:"error"`
 	Err_code int    `json:"error_code"`
 	Msg      string `json:"message"`
+	Offset   int64  `json:"offset"` //bytes of problem data the judger has received, see judger.sync
+}
+
+var (
+	errJudgerDown   = errors.New("judger is down")
+	errJudgeTimeout = errors.New("judger didn't call back before the deadline")
+	errJudgerSecret = errors.New("judger_secret is not configured")
+)
+
+// A task waiting for the callback of a judger
+type judgeTask struct {
+	job      *judgeJob
+	callback chan []byte
+}
+
+// Tasks waiting for callbacks, indexed by jid. Channels are buffered so that
+// FinishJudging never blocks, and a late callback of an abandoned task finds nothing.
+var (
+	judgeTasks    = make(map[string]judgeTask)
+	judgeTaskLock = sync.Mutex{}
+)
+
+// Assign a new task id to the judger and register its callback channel
+func (judger *Judger) newTask(job *judgeJob) (string, chan []byte) {
+	judgeTaskLock.Lock()
+	defer judgeTaskLock.Unlock()
+	jid := utils.RandomString(64)
+	callback := make(chan []byte, 1)
+	judgeTasks[jid] = judgeTask{job, callback}
+	return jid, callback
+}
+
+// Url for the judger to call back when the task finishes
+func judgeCallbackUrl(jid string, sid int, mode string) string {
+	return config.Global.BackDomain + "/FinishJudging?" + getQuery(map[string]string{
+		"jid":  jid,
+		"sid":  fmt.Sprint(sid),
+		"mode": mode,
+	})
+}
+
+/*
+Signature of a judge result: hex encoded HMAC-SHA256 with key config.Global.JudgerSecret
+over "<sid>\n<mode>\n<result>".
+*/
+func JudgeSignature(sid int, mode string, result []byte) string {
+	mac := hmac.New(sha256.New, []byte(config.Global.JudgerSecret))
+	fmt.Fprintf(mac, "%d\n%s\n", sid, mode)
+	mac.Write(result)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func judgeTaskDone(jid string) {
+	judgeTaskLock.Lock()
+	defer judgeTaskLock.Unlock()
+	delete(judgeTasks, jid)
+}
+
+// Wait for the callback of a task, fails when the judger goes down or the deadline of mode passes.
+func (judger *Judger) waitTask(jid string, callback chan []byte, mode string, down <-chan struct{}) ([]byte, error) {
+	defer judgeTaskDone(jid)
+	var deadline <-chan time.Time
+	if sec := config.Global.JudgeTimeout[mode]; sec > 0 {
+		timer := time.NewTimer(time.Duration(sec) * time.Second)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	select {
+	case ret := <-callback:
+		judger.setSuspect(false)
+		return ret, nil
+	case <-down:
+		return nil, errJudgerDown
+	case <-deadline:
+		judger.setSuspect(true)
+		return nil, errJudgeTimeout
+	}
+}
+
+func (judger *Judger) setSuspect(suspect bool) {
+	judgerLock.Lock()
+	changed := judger.Suspect != suspect
+	judger.Suspect = suspect
+	judgerLock.Unlock()
+	if suspect && changed {
+		//check it at once instead of waiting for the next round
+		go judger.probe()
+	}
+}
+
+// Take a free slot of the judger, returns false if all slots are in use.
+func (judger *Judger) acquire() bool {
+	judgerLock.Lock()
+	defer judgerLock.Unlock()
+	if judger.Running >= judger.Slots {
+		return false
+	}
+	judger.Running++
+	return true
 }
 
+func (judger *Judger) release() {
+	judgerLock.Lock()
+	defer judgerLock.Unlock()
+	judger.Running--
+	if judger.Pull && judger.Stopping && judger.Running == 0 {
+		delete(judgers, judger.Id)
+	}
+}
+
+// Run a dispatching goroutine for each slot, the judger is deleted after all of them stop.
 func judgerStart(judger *Judger) {
-	for {
+	var wg sync.WaitGroup
+	for i := 0; i < judger.Slots; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			judgerSlot(judger)
+		}()
+	}
+	wg.Wait()
+	judgerLock.Lock()
+	delete(judgers, judger.Id)
+	judgerLock.Unlock()
+}
+
+func judgerSlot(judger *Judger) {
+	for !judger.stopped() {
+		//wait until the judger is accepting entries
+		select {
+		case <-judger.stop:
+			return
+		case <-judger.upChan():
+		}
 		//wait for a submission
-		subm := waitingList.Pop()
-		sid, uuid, mode := subm.sid, subm.uuid, subm.mode
-		if mode != "custom_test" {
-			if !judgeSubmission(sid, uuid, mode, judger) {
-				db.Exec("update submissions set status=? where submission_id=?", InternalError, sid)
-				db.Exec("update submission_details set result=\"\", pretest_result=\"\", extra_result=\"\" where submission_id=?", sid)
-				sub, _ := SubmGetBaseInfo(sid)
-				SubmUpdate(sid, sub.ProblemId, subm.mode, []byte{})
+		subm, ok := waitingList.Pop(judger.Id, judger.caps().canJudge, judger.stop)
+		if !ok {
+			return
+		}
+		if cur, ok := judgerGet(judger.Id); !ok || cur != judger || judger.stopped() || !judger.accepting() {
+			//the judger has been removed, drained or is down while waiting, give the entry back
+			waitingList.Push(subm)
+			continue
+		}
+		if !judger.acquire() {
+			//all slots are in use, give the entry back and wait for one to be released
+			waitingList.Push(subm)
+			select {
+			case <-judger.stop:
+				return
+			case <-time.After(time.Second):
 			}
+			continue
+		}
+		judged, err := judgeEntry(subm, judger)
+		judger.release()
+		if err != nil {
+			judgeFailed(judger, subm, err)
 		} else {
-			judgeCustomTest(sid, subm.callback, judger)
+			//superseded entries and modes without data don't reach the judger
+			if judged {
+				judger.judged(subm)
+			}
+			waitingList.Done(subm)
 		}
-		//change the judger id to avoid attacks
-		judger.jid = utils.RandomString(64)
 	}
 }
 
-// return true or false indicates whether judging succeeds
-func judgeSubmission(sid int, uuid int64, mode string, judger *Judger) bool {
+// Requeue the entry if it may succeed on another try, otherwise report an internal error.
+func judgeFailed(judger *Judger, subm *JudgeEntry, err error) {
+	fmt.Println(err)
+	judgerLogf(judger.Id, subm.sid, "%s of submission %d failed: %v", subm.mode, subm.sid, err)
+	if err == errJudgerDown || !judger.isHealthy() {
+		//the judger is down, give the entry back to other judgers unless it keeps bringing them down
+		if subm.downs < judgeDownRetries {
+			subm.downs++
+			waitingList.Push(subm)
+			return
+		}
+		err = fmt.Errorf("judgers went down %d times: %v", subm.downs+1, err)
+	}
+	if err == errJudgeTimeout && subm.retries < config.Global.JudgeRetries {
+		subm.retries++
+		waitingList.Push(subm)
+		return
+	}
+	judgeAbort(subm, fmt.Sprintf("%s: %v", subm.mode, err))
+}
+
+// Finish an entry with an internal error unless it has been superseded
+func judgeAbort(subm *JudgeEntry, diagnostic string) {
+	waitingList.Done(subm)
+	sid := subm.sid
+	var err error
+	if subm.mode == "custom_test" {
+		err = customTestFinish(sid, []byte{})
+	} else {
+		var uuid int64
+		err = db.SelectSingleColumn(&uuid, "select uuid from submissions where submission_id=?", sid)
+		if err == nil && uuid == subm.uuid {
+			err = SubmFail(sid, subm.mode, diagnostic)
+		}
+	}
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Everything a judger needs to judge an entry
+type judgeJob struct {
+	entry    *JudgeEntry
+	prob     int    //problem id, 0 for custom tests
+	checkSum string //check sum of problem data
+	content  []byte //dumped submission
+}
+
+// Load the job of an entry, returns nil if there's nothing to judge.
+func judgeLoad(entry *JudgeEntry) (*judgeJob, error) {
+	sid, mode := entry.sid, entry.mode
+	job := &judgeJob{entry: entry}
+	if mode == "custom_test" {
+		err := db.SelectSingleColumn(&job.content, "select content from custom_tests where id=?", sid)
+		if err != nil {
+			return nil, err
+		}
+		return job, nil
+	}
 	type TempInfo struct {
 		Prob int   `db:"problem_id"`
 		Uuid int64 `db:"uuid"`
@@ -106,144 +600,220 @@ func judgeSubmission(sid int, uuid int64, mode string, judger *Judger) bool {
 	var tinfo TempInfo
 	err := db.SelectSingle(&tinfo, "select problem_id, uuid from submissions where submission_id=?", sid)
 	if err != nil {
-		fmt.Println(err)
-		return false
+		return nil, err
 	}
-	if tinfo.Uuid != uuid {
+	if tinfo.Uuid != entry.uuid {
 		//this judge entry isn't the recent entry in the judging queue
-		return true
+		return nil, nil
 	}
 
 	pro := ProbLoad(tinfo.Prob)
 	if !ProbHasData(pro, mode) {
-		go SubmUpdate(sid, tinfo.Prob, mode, []byte{})
-		return true
-	}
-	var content []byte
-	err = db.SelectSingleColumn(&content, "select content from submission_details where submission_id=?", sid)
-	go db.Exec("update submissions set status=status|? where submission_id=?", Waiting, sid)
-	var check_sum string
-	err1 := db.SelectSingleColumn(&check_sum, "select check_sum from problems where problem_id=?", tinfo.Prob)
-	if err != nil || err1 != nil {
-		fmt.Println(err, err1)
-		return false
+		go func() {
+			err := submNoData(sid, tinfo.Prob, mode)
+			if err != nil {
+				fmt.Printf("%v\n", err)
+			}
+		}()
+		return nil, nil
 	}
-
-	for { //Repeating for data sync
-		//get a new judger id
-		judger.jid = utils.RandomString(64)
-		res, err := http.Post(judger.url+"/judge?"+getQuery(map[string]string{
-			"mode": mode,
-			"sum":  check_sum,
-			"cb":   fmt.Sprintf(config.Global.BackDomain+"/FinishJudging?jid=%s", judger.jid),
-		}), "binary", bytes.NewBuffer(content))
-		if err != nil {
-			fmt.Printf("%v\n", err)
-			return false
+	job.prob = tinfo.Prob
+	err = db.SelectSingleColumn(&job.content, "select content from submission_details where submission_id=?", sid)
+	go func() {
+		_, err := db.Exec("update submissions set status=status|? where submission_id=?", Waiting, sid)
+		if err == nil {
+			Register("OnSubmJudging", sid, mode)
 		}
-		body, _ := io.ReadAll(res.Body)
-		var jr judgerResponse
-		jsoniter.Unmarshal(body, &jr)
+	}()
+	err1 := db.SelectSingleColumn(&job.checkSum, "select check_sum from problems where problem_id=?", tinfo.Prob)
+	if err != nil {
+		return nil, err
+	}
+	if err1 != nil {
+		return nil, err1
+	}
+	return job, nil
+}
 
-		if jr.Msg == "ok" {
-			break
-		} else if jr.Err_code == 1 {
-			ProblemRWLock.RLock(tinfo.Prob)
-			file, err := os.Open(ProbGetDataZip(tinfo.Prob))
-			res, err1 = http.Post(judger.url+"/sync?"+getQuery(map[string]string{"sum": check_sum}), "binary", file)
-			ProblemRWLock.RUnlock(tinfo.Prob)
-			if err != nil || err1 != nil {
-				fmt.Printf("%v %v\n", err, err1)
-				return false
-			}
-			body, _ = io.ReadAll(res.Body)
-			jsoniter.Unmarshal(body, &jr)
-			if jr.Msg != "ok" {
-				fmt.Printf("%s\n", jr.Err)
-				return false
-			}
-		} else {
-			fmt.Printf("%s\n", jr.Err)
-			return false
-		}
+// Handle the result of a finished job
+func judgeFinish(job *judgeJob, result []byte) error {
+	entry := job.entry
+	if entry.mode == "custom_test" {
+		return customTestFinish(entry.sid, result)
 	}
-	//Waiting judger finishes
-	ret := <-judger.callback
-	err = db.SelectSingleColumn(&tinfo.Uuid, "select uuid from submissions where submission_id=?", sid)
+	var uuid int64
+	err := db.SelectSingleColumn(&uuid, "select uuid from submissions where submission_id=?", entry.sid)
 	if err != nil {
-		fmt.Println(err)
-		return false
+		return err
 	}
-	if tinfo.Uuid == uuid {
+	if uuid == entry.uuid {
 		//Update status if and only if this is the recent submission
 		go func() {
-			err := SubmUpdate(sid, tinfo.Prob, mode, ret)
+			var err error
+			if _, perr := JudgeResultParse(result); perr != nil {
+				err = SubmFail(entry.sid, entry.mode, fmt.Sprintf("%s: invalid result: %v", entry.mode, perr))
+			} else {
+				err = SubmUpdate(entry.sid, job.prob, entry.mode, result)
+			}
 			if err != nil {
 				fmt.Printf("%v\n", err)
 			}
 		}()
 	}
-	return true
+	return nil
 }
 
-func judgeCustomTest(sid int, callback *chan []byte, judger *Judger) {
-	var content []byte
-	err := db.SelectSingleColumn(&content, "select content from custom_tests where id=?", sid)
+/*
+Push an entry to the judger and wait for the result, return nil if judging
+succeeds. judged is false if there's nothing to judge.
+*/
+func judgeEntry(entry *JudgeEntry, judger *Judger) (judged bool, err error) {
+	job, err := judgeLoad(entry)
+	if err != nil || job == nil {
+		return false, err
+	}
+	down := judger.downChan()
+	jid, callback := judger.newTask(job)
+	err = judger.dispatch(job, jid)
 	if err != nil {
-		fmt.Println(err)
-		*callback <- []byte{}
-		return
+		judgeTaskDone(jid)
+		return false, err
 	}
-	res, err := http.Post(judger.url+"/custom?"+getQuery(map[string]string{
-		"cb": fmt.Sprintf(config.Global.BackDomain+"/FinishJudging?jid=%s", judger.jid),
-	}), "binary", bytes.NewBuffer(content))
+	judgerLogf(judger.Id, entry.sid, "%s of submission %d dispatched", entry.mode, entry.sid)
+	//Waiting judger finishes
+	ret, err := judger.waitTask(jid, callback, entry.mode, down)
 	if err != nil {
-		fmt.Println(err)
-		*callback <- []byte{}
-		return
+		return false, err
+	}
+	return true, judgeFinish(job, ret)
+}
+
+// Send a job to a push judger, syncing problem data if the judger doesn't have it
+func (judger *Judger) dispatch(job *judgeJob, jid string) error {
+	sid, mode := job.entry.sid, job.entry.mode
+	if mode == "custom_test" {
+		jr, err := judger.post("/custom?"+getQuery(map[string]string{
+			"cb": judgeCallbackUrl(jid, sid, mode),
+		}), job.content)
+		if err != nil {
+			return err
+		}
+		if jr.Msg != "ok" {
+			return errors.New(jr.Err)
+		}
+		return nil
+	}
+
+	for resyncs := 0; ; resyncs++ { //Repeating for data sync
+		jr, err := judger.post("/judge?"+getQuery(map[string]string{
+			"mode": mode,
+			"sum":  job.checkSum,
+			"cb":   judgeCallbackUrl(jid, sid, mode),
+		}), job.content)
+		if err != nil {
+			return err
+		}
+
+		if jr.Msg == "ok" {
+			return nil
+		} else if jr.Err_code == judgerErrNoData {
+			if resyncs >= judgerResyncs {
+				return fmt.Errorf("judger %d still has no data of problem %d after %d syncs", judger.Id, job.prob, resyncs)
+			}
+			//the judger lost the data, sync it again
+			judgerLock.Lock()
+			delete(judger.synced, job.checkSum)
+			judgerLock.Unlock()
+			err := judger.sync(job.prob, job.checkSum)
+			if err != nil {
+				return err
+			}
+		} else {
+			return errors.New(jr.Err)
+		}
+	}
+}
+
+// Post content to the judger and decode its response, the judger is marked down if it can't be reached.
+func (judger *Judger) post(path string, content []byte) (*judgerResponse, error) {
+	res, err := http.Post(judger.Url+path, "binary", bytes.NewBuffer(content))
+	if err != nil {
+		judger.setHealthy(false)
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
 	}
-	body, _ := io.ReadAll(res.Body)
 	var jr judgerResponse
 	jsoniter.Unmarshal(body, &jr)
-	if jr.Msg != "ok" {
-		fmt.Println(jr.Err)
-		*callback <- []byte{}
-		return
-	}
-	*callback <- <-judger.callback
+	return &jr, nil
 }
 
-func InsertSubmission(sid int, uuid int64, priority int, mode string) {
-	waitingList.Push(&JudgeEntry{sid, mode, uuid, nil}, priority)
+func InsertSubmission(sid int, submitter int, uuid int64, priority int, mode string) error {
+	return waitingList.Push(&JudgeEntry{sid: sid, mode: mode, uuid: uuid, submitter: submitter, priority: priority})
 }
 
-func InsertCustomTest(sid int, callback *chan []byte) {
-	waitingList.Push(&JudgeEntry{sid, "custom_test", 0, callback}, 0)
+func InsertCustomTest(sid int, submitter int) error {
+	return waitingList.Push(&JudgeEntry{sid: sid, mode: "custom_test", submitter: submitter})
 }
 
-func FinishJudging(jid string, result []byte) error {
-	for i := 0; i < 5; i++ {
-		for key := range judgers {
-			if judgers[key].jid == jid {
-				judgers[key].callback <- result
-				return nil
-			}
+/*
+Deliver the result of a task. The signature is checked first, and the task
+must match the submission and mode it reports. Callbacks of unknown or
+abandoned tasks are discarded, so are all callbacks if config.Global.JudgerSecret
+is empty.
+*/
+func FinishJudging(jid string, sid int, mode string, signature string, result []byte) error {
+	if config.Global.JudgerSecret == "" {
+		return errJudgerSecret
+	}
+	expected := JudgeSignature(sid, mode, result)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("invalid signature: judger_id=%s", jid)
+	}
+	judgeTaskLock.Lock()
+	defer judgeTaskLock.Unlock()
+	task, ok := judgeTasks[jid]
+	if !ok {
+		return fmt.Errorf("no such task: judger_id=%s", jid)
+	}
+	if task.job.entry.sid != sid || task.job.entry.mode != mode {
+		return fmt.Errorf("task mismatch: judger_id=%s, submission_id=%d, mode=%s", jid, sid, mode)
+	}
+	delete(judgeTasks, jid)
+	var err error
+	if mode != "custom_test" {
+		if _, perr := JudgeResultParse(result); perr != nil {
+			err = fmt.Errorf("invalid result: %v", perr)
 		}
-		time.Sleep(time.Second)
 	}
-	return fmt.Errorf("no such judger: judger_id=%s", jid)
+	//invalid results are still delivered, so that judgeFinish ends the submission with an internal error
+	task.callback <- result
+	return err
 }
 
+// The whole log of the judger, or recent lines kept by the server if the judger can't be reached.
 func JudgerLog(id int) string {
-	if id >= len(judgers) {
+	judger, ok := judgerGet(id)
+	if ok && !judger.Pull {
+		res, err := http.Get(judger.Url + "/log")
+		if err == nil {
+			defer res.Body.Close()
+			body, _ := io.ReadAll(res.Body)
+			return string(body)
+		}
+	}
+	lines := JudgerLogRecent(id, 0)
+	if len(lines) == 0 {
 		return "No such judger"
 	}
-	res, err := http.Get(judgers[id].url + "/log")
-	if err != nil {
-		return "Internal server error"
+	ret := strings.Builder{}
+	for _, line := range lines {
+		ret.WriteString(line.Text + "\n")
 	}
-	body, _ := io.ReadAll(res.Body)
-	return string(body)
+	return ret.String()
 }
 
 func getQuery(query map[string]string) string {
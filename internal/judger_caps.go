@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"yao/db"
+
+	utils "github.com/super-yaoj/yaoj-utils"
+)
+
+// What a judger is able to judge, the zero value means it can judge everything.
+type JudgerCaps struct {
+	//supported languages, empty for all languages
+	Langs []utils.LangTag `json:"langs"`
+	//max memory limit in MB (the unit of _ml in problem statements), non-positive for unlimited
+	MaxMemory int `json:"max_memory"`
+	//special tags required by problems with the _judger_tags statement
+	Tags []string `json:"tags"`
+}
+
+// What an entry requires the judger to have
+type judgeNeeds struct {
+	langs  []utils.LangTag
+	memory int
+	tags   []string
+}
+
+func (caps JudgerCaps) satisfies(needs judgeNeeds) bool {
+	if len(caps.Langs) > 0 {
+		for _, lang := range needs.langs {
+			if !utils.HasElement(caps.Langs, lang) {
+				return false
+			}
+		}
+	}
+	if caps.MaxMemory > 0 && needs.memory > caps.MaxMemory {
+		return false
+	}
+	for _, tag := range needs.tags {
+		if !utils.HasElement(caps.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// Copy of the capabilities of the judger
+func (judger *Judger) caps() JudgerCaps {
+	judgerLock.RLock()
+	defer judgerLock.RUnlock()
+	return judger.Caps
+}
+
+// Whether the judger is able to judge the entry
+func (caps JudgerCaps) canJudge(entry *JudgeEntry) bool {
+	return caps.satisfies(entry.needs)
+}
+
+/*
+Find out what the entry requires. The languages are the language of the
+submission, or every language allowed by the problem's SubmConfig if the
+submission's language is unknown (e.g. submitted by zip).
+*/
+func judgeNeedsOf(entry *JudgeEntry) (judgeNeeds, error) {
+	var needs judgeNeeds
+	if entry.mode == "custom_test" {
+		var lang int
+		err := db.SelectSingleColumn(&lang, "select language from custom_tests where id=?", entry.sid)
+		if err != nil {
+			return needs, err
+		}
+		if lang >= 0 {
+			needs.langs = []utils.LangTag{utils.LangTag(lang)}
+		}
+		return needs, nil
+	}
+	var sub Submission
+	err := db.SelectSingle(&sub, "select problem_id, language from submissions where submission_id=?", entry.sid)
+	if err != nil {
+		return needs, err
+	}
+	pro := ProbLoad(sub.ProblemId)
+	if sub.Language >= 0 {
+		needs.langs = []utils.LangTag{utils.LangTag(sub.Language)}
+	} else {
+		for _, limit := range pro.SubmConfig {
+			if limit.Accepted != utils.Csource {
+				continue
+			}
+			for _, lang := range limit.Langs {
+				if !utils.HasElement(needs.langs, lang) {
+					needs.langs = append(needs.langs, lang)
+				}
+			}
+		}
+	}
+	needs.memory = pro.MemoryLimit
+	needs.tags = pro.JudgerTags
+	return needs, nil
+}
+
+// Parse capabilities from comma-separated languages and tags
+func JudgerCapsParse(langs string, maxMemory int, tags string) (JudgerCaps, error) {
+	caps := JudgerCaps{MaxMemory: maxMemory, Tags: judgerTagsParse(tags)}
+	for _, lang := range judgerTagsParse(langs) {
+		tag, err := strconv.Atoi(lang)
+		if err != nil || tag < 0 || tag >= len(utils.LangSuf) {
+			return caps, fmt.Errorf("invalid language: %s", lang)
+		}
+		caps.Langs = append(caps.Langs, utils.LangTag(tag))
+	}
+	return caps, nil
+}
+
+// Parse comma-separated tags, e.g. the _judger_tags statement of problems
+func judgerTagsParse(tags string) []string {
+	ret := []string{}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			ret = append(ret, tag)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,224 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Logs of judgers are collected into ring buffers of the most recent lines, so
+they can be tailed live and are still viewable after judgers go offline.
+
+Lines of push judgers are fetched from /log with range requests every second
+while someone is following the log, and every judgerLogPullEvery otherwise, so
+recent lines survive the judger going offline. Each fetch reads at most
+judgerLogPullBytes, the rest is fetched next time. The server also writes lines
+about the entries it gives to judgers.
+*/
+const (
+	judgerLogLines     = 1000
+	judgerLogPullBytes = 1 << 20
+	judgerLogPullEvery = 10 * time.Second
+)
+
+type JudgerLogLine struct {
+	Time time.Time `json:"time"`
+	//0 if the line isn't about a submission
+	Sid int `json:"submission_id"`
+	//true if the line is written by the server rather than the judger
+	Server bool   `json:"server"`
+	Text   string `json:"text"`
+}
+
+type judgerLogRing struct {
+	lines []JudgerLogLine
+	//index of the oldest line once the ring is full
+	next int
+	//bytes of the judger's /log received, and the trailing incomplete line
+	seen    int64
+	partial string
+	//held while fetching /log, so that fetches of the same judger don't overlap
+	pull sync.Mutex
+	//followers and the submission ids they filter by
+	subs map[chan JudgerLogLine]int
+}
+
+var (
+	judgerLogs    = make(map[int]*judgerLogRing)
+	judgerLogLock sync.Mutex
+	//submission ids mentioned in lines of judgers, e.g. "submission 12" or "sid=12"
+	judgerLogSid = regexp.MustCompile(`(?i)\b(?:sid|submission(?:_id)?)[\s=:#]*(\d+)`)
+)
+
+// You should hold judgerLogLock before calling this function.
+func judgerLogRingGet(id int) *judgerLogRing {
+	ring, ok := judgerLogs[id]
+	if !ok {
+		ring = &judgerLogRing{subs: make(map[chan JudgerLogLine]int)}
+		judgerLogs[id] = ring
+	}
+	return ring
+}
+
+// You should hold judgerLogLock before calling this function.
+func (ring *judgerLogRing) append(line JudgerLogLine) {
+	if len(ring.lines) < judgerLogLines {
+		ring.lines = append(ring.lines, line)
+	} else {
+		ring.lines[ring.next] = line
+		ring.next = (ring.next + 1) % judgerLogLines
+	}
+	for ch, sid := range ring.subs {
+		if sid == 0 || sid == line.Sid {
+			select {
+			case ch <- line:
+			default: //drop lines for slow followers
+			}
+		}
+	}
+}
+
+// Lines in order, filtered by submission id unless sid is 0.
+// You should hold judgerLogLock before calling this function.
+func (ring *judgerLogRing) list(sid int) []JudgerLogLine {
+	ret := []JudgerLogLine{}
+	for i := range ring.lines {
+		line := ring.lines[(ring.next+i)%len(ring.lines)]
+		if sid == 0 || line.Sid == sid {
+			ret = append(ret, line)
+		}
+	}
+	return ret
+}
+
+// Write a line about a submission to the log of a judger
+func judgerLogf(id int, sid int, format string, args ...any) {
+	judgerLogLock.Lock()
+	defer judgerLogLock.Unlock()
+	judgerLogRingGet(id).append(JudgerLogLine{time.Now(), sid, true, fmt.Sprintf(format, args...)})
+}
+
+/*
+Fetch new lines from /log of the judger, returns whether the judger responds.
+Judgers not supporting range requests send the whole log, which is cut at the
+bytes already received.
+*/
+func (judger *Judger) pullLog() bool {
+	judgerLogLock.Lock()
+	ring := judgerLogRingGet(judger.Id)
+	judgerLogLock.Unlock()
+	ring.pull.Lock()
+	defer ring.pull.Unlock()
+	judgerLogLock.Lock()
+	seen := ring.seen
+	judgerLogLock.Unlock()
+	req, err := http.NewRequest(http.MethodGet, judger.Url+"/log", nil)
+	if err != nil {
+		return false
+	}
+	if seen > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", seen))
+	}
+	res, err := probeClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer res.Body.Close()
+	var data []byte
+	switch res.StatusCode {
+	case http.StatusRequestedRangeNotSatisfiable:
+		//nothing new
+		return true
+	case http.StatusPartialContent, http.StatusOK:
+		limit := int64(judgerLogPullBytes)
+		if res.StatusCode == http.StatusOK {
+			limit += seen
+		}
+		data, err = io.ReadAll(io.LimitReader(res.Body, limit))
+		if err != nil {
+			return false
+		}
+	default:
+		return false
+	}
+	judgerLogLock.Lock()
+	defer judgerLogLock.Unlock()
+	if res.StatusCode == http.StatusOK {
+		if int64(len(data)) >= ring.seen {
+			data = data[ring.seen:]
+		} else {
+			//the log is truncated, e.g. the judger restarted
+			ring.partial = ""
+			ring.seen = 0
+		}
+	}
+	ring.seen += int64(len(data))
+	text := ring.partial + string(data)
+	lines := strings.Split(text, "\n")
+	ring.partial = lines[len(lines)-1]
+	now := time.Now()
+	for _, text := range lines[:len(lines)-1] {
+		line := JudgerLogLine{Time: now, Text: strings.TrimRight(text, "\r")}
+		if match := judgerLogSid.FindStringSubmatch(text); match != nil {
+			line.Sid, _ = strconv.Atoi(match[1])
+		}
+		ring.append(line)
+	}
+	return true
+}
+
+/*
+Follow the log of a judger, returns recent lines and a channel of new lines,
+lines are filtered by submission id unless sid is 0. Call cancel once done.
+*/
+func JudgerLogFollow(id int, sid int) (lines []JudgerLogLine, ch <-chan JudgerLogLine, cancel func()) {
+	judgerLogLock.Lock()
+	defer judgerLogLock.Unlock()
+	ring := judgerLogRingGet(id)
+	c := make(chan JudgerLogLine, 100)
+	ring.subs[c] = sid
+	return ring.list(sid), c, func() {
+		judgerLogLock.Lock()
+		defer judgerLogLock.Unlock()
+		delete(ring.subs, c)
+	}
+}
+
+// Recent lines of a judger, lines are filtered by submission id unless sid is 0.
+func JudgerLogRecent(id int, sid int) []JudgerLogLine {
+	judgerLogLock.Lock()
+	defer judgerLogLock.Unlock()
+	if ring, ok := judgerLogs[id]; ok {
+		return ring.list(sid)
+	}
+	return []JudgerLogLine{}
+}
+
+// Fetch logs of followed push judgers every second, and of other healthy ones every judgerLogPullEvery
+func judgerLogFollowStart() {
+	last := time.Now()
+	for now := range time.Tick(time.Second) {
+		all := now.Sub(last) >= judgerLogPullEvery
+		if all {
+			last = now
+		}
+		for _, judger := range judgerAll() {
+			if judger.Pull {
+				continue
+			}
+			judgerLogLock.Lock()
+			ring, ok := judgerLogs[judger.Id]
+			followed := ok && len(ring.subs) > 0
+			judgerLogLock.Unlock()
+			if followed || (all && judger.isHealthy()) {
+				judger.pullLog()
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A judger whose /log has n lines and doesn't support range requests
+func testLogJudger(t *testing.T, id int, n int) *Judger {
+	text := strings.Builder{}
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&text, "judging submission %d\n", i)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, text.String())
+	}))
+	t.Cleanup(func() {
+		server.Close()
+		judgerLogLock.Lock()
+		delete(judgerLogs, id)
+		judgerLogLock.Unlock()
+	})
+	return NewJudger(id, server.URL, 1)
+}
+
+func TestJudgerPullLog(t *testing.T) {
+	judger := testLogJudger(t, -1, 50)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !judger.pullLog() {
+				t.Error("pullLog() fails")
+			}
+		}()
+	}
+	wg.Wait()
+	lines := JudgerLogRecent(judger.Id, 0)
+	if len(lines) != 50 {
+		t.Fatalf("%d lines are kept, want 50", len(lines))
+	}
+	for i, line := range lines {
+		if line.Sid != i+1 {
+			t.Fatalf("line %d is about submission %d", i+1, line.Sid)
+		}
+	}
+}
+
+func TestJudgerProbe(t *testing.T) {
+	judger := testLogJudger(t, -2, 50)
+	judger.probe()
+	if !judger.isHealthy() {
+		t.Error("judger is unhealthy")
+	}
+	if lines := JudgerLogRecent(judger.Id, 0); len(lines) != 0 {
+		t.Errorf("probing fetches %d lines of the log", len(lines))
+	}
+}
+
+func TestJudgerPullLogBounded(t *testing.T) {
+	//the log is larger than a single fetch reads
+	n := 2 * judgerLogPullBytes / len("judging submission 100000\n")
+	judger := testLogJudger(t, -3, n)
+	for i := 0; i < 3; i++ {
+		if !judger.pullLog() {
+			t.Fatal("pullLog() fails")
+		}
+	}
+	lines := JudgerLogRecent(judger.Id, 0)
+	if len(lines) != judgerLogLines {
+		t.Fatalf("%d lines are kept, want %d", len(lines), judgerLogLines)
+	}
+	if last := lines[len(lines)-1]; last.Sid != n {
+		t.Fatalf("the last line is about submission %d, want %d", last.Sid, n)
+	}
+}
@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"yao/config"
+
+	utils "github.com/super-yaoj/yaoj-utils"
+)
+
+/*
+Pull mode: instead of receiving pushed jobs, judgers long-poll JudgerLease to
+lease the entry with the highest priority, fetch the submission and problem
+data with the lease token, and report the result to FinishJudging with the
+token as jid. Judgers behind NAT can join without inbound ports.
+
+Pull judgers are registered by name on their first lease and are never probed,
+a lost pull judger is detected by the deadline of its tasks. Names of removed
+pull judgers can't lease any more until they are added again by JudgerPullAdd,
+and leases in progress are finished before the judger leaves the registry.
+*/
+
+type JudgeLease struct {
+	Token     string `json:"token"`
+	Sid       int    `json:"submission_id"`
+	Mode      string `json:"mode"`
+	ProblemId int    `json:"problem_id"`
+	CheckSum  string `json:"check_sum"`
+}
+
+// Leases are requested with a timestamp to avoid replaying
+const leaseMaxSkew = 5 * time.Minute
+
+// names of removed pull judgers, guarded by judgerLock
+var judgerPullRemoved = make(map[string]bool)
+
+/*
+Check the signature of a lease request, which is JudgeSignature(0, "lease", "<name>\n<timestamp>").
+Pull mode is disabled when config.Global.JudgerSecret is empty.
+*/
+func JudgerLeaseCheck(name string, timestamp string, signature string) error {
+	if config.Global.JudgerSecret == "" {
+		return errors.New("pull mode is disabled")
+	}
+	expected := JudgeSignature(0, "lease", []byte(name+"\n"+timestamp))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("invalid signature")
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return err
+	}
+	skew := time.Since(time.Unix(sec, 0))
+	if skew > leaseMaxSkew || skew < -leaseMaxSkew {
+		return errors.New("timestamp expired")
+	}
+	judgerLock.RLock()
+	defer judgerLock.RUnlock()
+	if judgerPullRemoved[name] {
+		return fmt.Errorf("judger %s is removed", name)
+	}
+	return nil
+}
+
+// Register a pull judger ahead of its first lease, names of removed judgers are allowed to lease again
+func JudgerPullAdd(name string, slots int, caps JudgerCaps) (*Judger, error) {
+	if config.Global.JudgerSecret == "" {
+		return nil, errJudgerSecret
+	}
+	judgerLock.Lock()
+	delete(judgerPullRemoved, name)
+	judgerLock.Unlock()
+	return judgerPullGet(name, slots, caps)
+}
+
+// Get the pull judger by name and update its slots and capabilities, register it if it doesn't exist
+func judgerPullGet(name string, slots int, caps JudgerCaps) (*Judger, error) {
+	judgerLock.Lock()
+	defer judgerLock.Unlock()
+	if judgerPullRemoved[name] {
+		return nil, fmt.Errorf("judger %s is removed", name)
+	}
+	for _, judger := range judgers {
+		if judger.Pull && judger.Url == name && !judger.Stopping {
+			judger.LastCheck = time.Now()
+			judger.Slots = utils.If(slots > 0, slots, 1)
+			judger.Caps = caps
+			return judger, nil
+		}
+	}
+	judgerLastId++
+	judger := NewJudger(judgerLastId, name, slots)
+	judger.Pull = true
+	judger.LastCheck = time.Now()
+	judger.Caps = caps
+	judgers[judger.Id] = judger
+	return judger, nil
+}
+
+/*
+Lease the entry with the highest priority for the pull judger, waiting at most
+wait for one. Returns nil if there's no entry or all slots of the judger are in
+use, a slot is taken until the result of the lease is reported or it expires.
+*/
+func JudgerLease(name string, slots int, caps JudgerCaps, wait time.Duration) (*JudgeLease, error) {
+	judger, err := judgerPullGet(name, slots, caps)
+	if err != nil {
+		return nil, err
+	}
+	if !judger.accepting() || !judger.acquire() {
+		return nil, nil
+	}
+	timeout := time.After(wait)
+	for {
+		entry, ok := waitingList.PopTimeout(judger.Id, caps.canJudge, timeout)
+		if !ok {
+			judger.release()
+			return nil, nil
+		}
+		job, err := judgeLoad(entry)
+		if err != nil {
+			judgeFailed(judger, entry, err)
+			continue
+		}
+		if job == nil {
+			waitingList.Done(entry)
+			continue
+		}
+		jid, callback := judger.newTask(job)
+		judgerLogf(judger.Id, entry.sid, "%s of submission %d leased", entry.mode, entry.sid)
+		go func() {
+			ret, err := judger.waitTask(jid, callback, entry.mode, judger.downChan())
+			judger.release()
+			if err == nil {
+				err = judgeFinish(job, ret)
+			}
+			if err != nil {
+				judgeFailed(judger, entry, err)
+			} else {
+				judger.judged(entry)
+				waitingList.Done(entry)
+			}
+		}()
+		return &JudgeLease{jid, entry.sid, entry.mode, job.prob, job.checkSum}, nil
+	}
+}
+
+func leaseGet(token string) (*judgeJob, error) {
+	judgeTaskLock.Lock()
+	defer judgeTaskLock.Unlock()
+	task, ok := judgeTasks[token]
+	if !ok {
+		return nil, fmt.Errorf("no such lease: token=%s", token)
+	}
+	return task.job, nil
+}
+
+// Dumped submission of a leased task
+func JudgerLeaseContent(token string) ([]byte, error) {
+	job, err := leaseGet(token)
+	if err != nil {
+		return nil, err
+	}
+	return job.content, nil
+}
+
+// Problem id of a leased task, whose data can be read from ProbGetDataZip
+func JudgerLeaseProblem(token string) (int, error) {
+	job, err := leaseGet(token)
+	if err != nil {
+		return 0, err
+	}
+	if job.prob == 0 {
+		return 0, errors.New("custom tests have no problem data")
+	}
+	return job.prob, nil
+}
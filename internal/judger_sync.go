@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+	"yao/config"
+	"yao/db"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+/*
+Problem data is synced to judgers in chunks:
+
+ 1. GET /sync?sum=<check sum> answers the offset the judger has received.
+ 2. Each chunk is posted to /sync?sum=&offset=&size=&hash=, where hash is the
+    hex sha256 of the chunk. The judger answers error_code 3 along with its
+    offset if offset doesn't match, so the sync resumes from there.
+ 3. The judger checks the whole file once it has size bytes.
+
+The data zip is opened under the reading lock only, an opened file stays
+readable even if the problem data is replaced during the transfer.
+*/
+const (
+	syncChunkSize    = 4 << 20
+	syncChunkRetries = 3
+	//the judger has a different offset from the request
+	syncErrOffset = 3
+)
+
+// Open the data zip of a problem, the file stays readable after the data is replaced.
+func ProbOpenData(problem_id int) (*os.File, error) {
+	ProblemRWLock.RLock(problem_id)
+	defer ProblemRWLock.RUnlock(problem_id)
+	return os.Open(ProbGetDataZip(problem_id))
+}
+
+func (judger *Judger) isSynced(sum string) bool {
+	judgerLock.RLock()
+	defer judgerLock.RUnlock()
+	return judger.synced[sum]
+}
+
+// Sync the data of problem prob to the judger, unless it has been synced
+func (judger *Judger) sync(prob int, sum string) error {
+	judger.syncLock.Lock()
+	defer judger.syncLock.Unlock()
+	if judger.isSynced(sum) {
+		return nil
+	}
+	file, err := ProbOpenData(prob)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := stat.Size()
+
+	var jr judgerResponse
+	err = judger.syncRequest(http.MethodGet, getQuery(map[string]string{"sum": sum}), nil, &jr)
+	if err != nil {
+		return err
+	}
+	offset := jr.Offset
+	if offset < 0 || offset > size {
+		return fmt.Errorf("invalid offset %d of %d bytes", offset, size)
+	}
+	//times the judger answers a different offset, which happens at most a few times normally
+	resets := 0
+	buf := make([]byte, syncChunkSize)
+	for offset < size {
+		n, err := file.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		chunk := buf[:n]
+		hash := sha256.Sum256(chunk)
+		query := getQuery(map[string]string{
+			"sum":    sum,
+			"offset": fmt.Sprint(offset),
+			"size":   fmt.Sprint(size),
+			"hash":   hex.EncodeToString(hash[:]),
+		})
+		for try := 0; ; try++ {
+			err = judger.syncRequest(http.MethodPost, query, chunk, &jr)
+			if err == nil {
+				offset += int64(n)
+				break
+			}
+			if jr.Err_code == syncErrOffset {
+				if jr.Offset < 0 || jr.Offset > size {
+					return fmt.Errorf("invalid offset %d of %d bytes", jr.Offset, size)
+				}
+				resets++
+				if resets > syncChunkRetries {
+					return fmt.Errorf("offset reset too many times: %v", err)
+				}
+				offset = jr.Offset
+				break
+			}
+			if try >= syncChunkRetries {
+				return err
+			}
+		}
+	}
+	judgerLock.Lock()
+	judger.synced[sum] = true
+	judgerLock.Unlock()
+	return nil
+}
+
+// The judger is marked as unhealthy if it can't be reached
+func (judger *Judger) syncRequest(method string, query string, body []byte, jr *judgerResponse) error {
+	req, err := http.NewRequest(method, judger.Url+"/sync?"+query, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		judger.setHealthy(false)
+		return err
+	}
+	defer res.Body.Close()
+	content, _ := io.ReadAll(res.Body)
+	*jr = judgerResponse{}
+	jsoniter.Unmarshal(content, jr)
+	if jr.Msg != "ok" {
+		return errors.New(jr.Err)
+	}
+	return nil
+}
+
+type JudgePrefetch struct {
+	ProblemId int    `db:"problem_id" json:"problem_id"`
+	CheckSum  string `db:"check_sum" json:"check_sum"`
+}
+
+// Problems of contests starting within config.Global.JudgerPrefetch minutes or running
+func JudgePrefetchList() ([]JudgePrefetch, error) {
+	var ret []JudgePrefetch
+	err := db.SelectAll(&ret, "select distinct problems.problem_id, problems.check_sum from contest_problems join contests on contest_problems.contest_id=contests.contest_id join problems on contest_problems.problem_id=problems.problem_id where contests.start_time<? and contests.end_time>?",
+		time.Now().Add(time.Duration(config.Global.JudgerPrefetch)*time.Minute), time.Now())
+	return ret, err
+}
+
+// Whether the problem is in JudgePrefetchList
+func JudgePrefetchHas(problem_id int) bool {
+	list, err := JudgePrefetchList()
+	if err != nil {
+		return false
+	}
+	for _, prob := range list {
+		if prob.ProblemId == problem_id {
+			return true
+		}
+	}
+	return false
+}
+
+// Sync data of problems in upcoming contests to accepting push judgers every minute
+func judgerPrefetchStart() {
+	if config.Global.JudgerPrefetch <= 0 {
+		return
+	}
+	for range time.Tick(time.Minute) {
+		list, err := JudgePrefetchList()
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		for _, judger := range judgerAll() {
+			if judger.Pull || !judger.accepting() {
+				continue
+			}
+			go func(judger *Judger) {
+				for _, prob := range list {
+					if prob.CheckSum == "" {
+						continue
+					}
+					err := judger.sync(prob.ProblemId, prob.CheckSum)
+					if err != nil {
+						fmt.Printf("prefetch problem %d to judger %d: %v\n", prob.ProblemId, judger.Id, err)
+						return
+					}
+				}
+			}(judger)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"yao/config"
+	"yao/fakejudger"
+)
+
+// Write problem data of n bytes for problem -1
+func testSyncData(t *testing.T, n int) []byte {
+	old := config.Global.DataDir
+	config.Global.DataDir = t.TempDir() + "/"
+	t.Cleanup(func() { config.Global.DataDir = old })
+	data := bytes.Repeat([]byte("yaoj"), n/4)
+	err := os.WriteFile(ProbGetDataZip(-1), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestJudgerSync(t *testing.T) {
+	data := testSyncData(t, syncChunkSize+1000)
+	fake := fakejudger.New("")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	judger := NewJudger(-1, server.URL, 1)
+	err := judger.sync(-1, "sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fake.Data("sum"), data) || !judger.isSynced("sum") {
+		t.Fatal("data isn't synced")
+	}
+}
+
+func TestJudgerSyncBadOffset(t *testing.T) {
+	size := int64(len(testSyncData(t, 1000)))
+	tests := []struct {
+		name string
+		//offsets answered to GET and POST
+		get, post int64
+	}{
+		{"always resets", 0, 0},
+		{"beyond the end", 0, size + 1},
+		{"negative", 0, -1},
+		{"starts beyond the end", size + 1, 0},
+	}
+	for _, test := range tests {
+		posts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				json.NewEncoder(w).Encode(judgerResponse{Msg: "ok", Offset: test.get})
+				return
+			}
+			posts++
+			json.NewEncoder(w).Encode(judgerResponse{Err: "offset mismatch", Err_code: syncErrOffset, Offset: test.post})
+		}))
+		judger := NewJudger(-1, server.URL, 1)
+		err := judger.sync(-1, "sum")
+		server.Close()
+		if err == nil || judger.isSynced("sum") {
+			t.Errorf("%s: sync succeeds", test.name)
+		} else if !strings.Contains(err.Error(), "offset") {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if posts > syncChunkRetries+1 {
+			t.Errorf("%s: %d chunks are posted", test.name, posts)
+		}
+	}
+}
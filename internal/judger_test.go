@@ -0,0 +1,231 @@
+package internal
+
+import (
+	"testing"
+	"time"
+	"yao/config"
+	"yao/db"
+)
+
+// Use an empty judging queue in the test
+func testQueue(t *testing.T) {
+	old := waitingList
+	waitingList = newJudgeQueue()
+	t.Cleanup(func() { waitingList = old })
+}
+
+// Run a dispatching goroutine of the judger, the returned channel is closed when it returns
+func testSlot(judger *Judger) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		judgerSlot(judger)
+		close(done)
+	}()
+	return done
+}
+
+// Wait until the only entry in the queue has been popped and given back
+func testRequeued(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		entries := waitingList.List()
+		if len(entries) == 1 && entries[0].judger == 0 && !entries[0].startTime.IsZero() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("entry is not given back to the queue: %+v", entries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testStopped(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("judgerSlot doesn't stop")
+	}
+}
+
+func TestJudgerSlotFull(t *testing.T) {
+	testQueue(t)
+	testSecret(t)
+	judger, _ := JudgerAdd("", 1, JudgerCaps{})
+	//the only slot is taken, e.g. by a task that hasn't released it yet
+	judgerLock.Lock()
+	judger.Running = 1
+	judgerLock.Unlock()
+	waitingList.push(&JudgeEntry{id: 1, sid: 1, mode: "tests"})
+	testRequeued(t)
+	judgerLock.RLock()
+	running := judger.Running
+	judgerLock.RUnlock()
+	if running != 1 {
+		t.Errorf("running = %d, want 1", running)
+	}
+	JudgerRemove(judger.Id)
+	testWait(t, "the judger to be deleted", func() bool {
+		_, ok := judgerGet(judger.Id)
+		return !ok
+	})
+}
+
+func TestJudgerSlotRemoved(t *testing.T) {
+	testQueue(t)
+	//waiting for entries on an empty queue
+	testSecret(t)
+	judger, _ := JudgerAdd("", 2, JudgerCaps{})
+	time.Sleep(10 * time.Millisecond)
+	JudgerRemove(judger.Id)
+	testWait(t, "the judger to be deleted", func() bool {
+		_, ok := judgerGet(judger.Id)
+		return !ok
+	})
+
+	//an entry popped by a judger which isn't in the registry is given back
+	judger = NewJudger(-1, "", 1)
+	done := testSlot(judger)
+	waitingList.push(&JudgeEntry{id: 1, sid: 1, mode: "tests"})
+	testRequeued(t)
+	close(judger.stop)
+	testStopped(t, done)
+}
+
+func TestJudgerDurations(t *testing.T) {
+	testDB(t)
+	testQueue(t)
+	pid, _ := testProblem(t, "tests")
+	sub := testSubmission(t, 1, pid, 0)
+	testSecret(t)
+	judger, _ := JudgerAdd("", 1, JudgerCaps{})
+	t.Cleanup(func() { JudgerRemove(judger.Id) })
+	//superseded by a later rejudge, so there's nothing to judge
+	err := InsertSubmission(sub.Id, sub.Submitter, -1, 0, "tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testWait(t, "the entry to be done", func() bool { return len(waitingList.List()) == 0 })
+	judgerLock.RLock()
+	durations := len(judger.durations)
+	judgerLock.RUnlock()
+	if durations != 0 {
+		t.Fatalf("%d durations are recorded for entries not judged", durations)
+	}
+}
+
+func TestFinishJudgingSignature(t *testing.T) {
+	old := config.Global.JudgerSecret
+	t.Cleanup(func() { config.Global.JudgerSecret = old })
+	config.Global.JudgerSecret = "secret"
+	judger := NewJudger(-1, "", 1)
+	jid, callback := judger.newTask(&judgeJob{entry: &JudgeEntry{sid: 1, mode: "tests"}})
+	defer judgeTaskDone(jid)
+	result := []byte(`{"IsSubtask":false,"Subtask":[]}`)
+
+	for _, signature := range []string{"", "bad", JudgeSignature(2, "tests", result), JudgeSignature(1, "pretest", result)} {
+		if err := FinishJudging(jid, 1, "tests", signature, result); err == nil {
+			t.Errorf("signature %q is accepted", signature)
+		}
+	}
+	config.Global.JudgerSecret = ""
+	if err := FinishJudging(jid, 1, "tests", JudgeSignature(1, "tests", result), result); err == nil {
+		t.Error("results are accepted without judger_secret")
+	}
+	select {
+	case <-callback:
+		t.Fatal("result with an invalid signature is delivered")
+	default:
+	}
+
+	config.Global.JudgerSecret = "secret"
+	if err := FinishJudging(jid, 1, "tests", JudgeSignature(1, "tests", result), result); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-callback:
+		if string(got) != string(result) {
+			t.Errorf("delivered %s, want %s", got, result)
+		}
+	default:
+		t.Fatal("result is not delivered")
+	}
+}
+
+func TestJudgerAddSecret(t *testing.T) {
+	old := config.Global.JudgerSecret
+	t.Cleanup(func() { config.Global.JudgerSecret = old })
+	config.Global.JudgerSecret = ""
+	if judger, err := JudgerAdd("http://localhost:1", 1, JudgerCaps{}); err == nil {
+		JudgerRemove(judger.Id)
+		t.Fatal("judger is added without judger_secret")
+	}
+}
+
+func TestJudgeFailedDown(t *testing.T) {
+	testDB(t)
+	testQueue(t)
+	pid, _ := testProblem(t, "tests")
+	sub := testSubmission(t, 1, pid, 0)
+	var uuid int64
+	err := db.SelectSingleColumn(&uuid, "select uuid from submissions where submission_id=?", sub.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = waitingList.Push(&JudgeEntry{sid: sub.Id, mode: "tests", uuid: uuid, submitter: sub.Submitter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	judger := &Judger{Id: -1}
+	all := func(*JudgeEntry) bool { return true }
+	//requeued while judgers are down, until it has brought down too many of them
+	for i := 0; i <= judgeDownRetries; i++ {
+		subm, ok := waitingList.PopTimeout(judger.Id, all, time.After(5*time.Second))
+		if !ok {
+			t.Fatalf("entry isn't requeued after %d downs", i)
+		}
+		judgeFailed(judger, subm, errJudgerDown)
+	}
+	if entries := waitingList.List(); len(entries) != 0 {
+		t.Fatalf("entry is still queued after %d downs: %+v", judgeDownRetries+1, entries)
+	}
+	if status := testSubmStatus(t, sub.Id); status != InternalError {
+		t.Fatalf("status = %d, want %d", status, InternalError)
+	}
+}
+
+func TestJudgerPullRemove(t *testing.T) {
+	testSecret(t)
+	judger, err := JudgerPullAdd(t.Name(), 1, JudgerCaps{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		JudgerRemove(judger.Id)
+		judgerLock.Lock()
+		delete(judgerPullRemoved, t.Name())
+		judgerLock.Unlock()
+	})
+	//a lease is in progress
+	if !judger.acquire() {
+		t.Fatal("judger has no free slot")
+	}
+	if err := JudgerRemove(judger.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := judgerPullGet(t.Name(), 1, JudgerCaps{}); err == nil {
+		t.Fatal("removed judger leases again")
+	}
+	if _, ok := judgerGet(judger.Id); !ok {
+		t.Fatal("judger leaves before its lease is finished")
+	}
+	judger.release()
+	if _, ok := judgerGet(judger.Id); ok {
+		t.Fatal("judger doesn't leave after its lease is finished")
+	}
+	judger, err = JudgerPullAdd(t.Name(), 1, JudgerCaps{})
+	if err != nil {
+		t.Fatalf("judger can't be added again: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+	"yao/config"
+	"yao/db"
+	"yao/fakejudger"
+
+	"github.com/super-yaoj/yaoj-core/pkg/problem"
+	utils "github.com/super-yaoj/yaoj-utils"
+)
+
+/*
+Tests using the database run only if YAO_TEST_DSN is set to the data source
+name of a database initialized by yao_init.sql, e.g.
+
+	YAO_TEST_DSN="yaoj@tcp(127.0.0.1:3306)/yaoj_test?charset=utf8mb4&parseTime=True" go test ./internal
+*/
+var testDSN = os.Getenv("YAO_TEST_DSN")
+
+func TestMain(m *testing.M) {
+	if testDSN != "" {
+		err := db.Init(testDSN)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+func testDB(t *testing.T) {
+	t.Helper()
+	if testDSN == "" {
+		t.Skip("YAO_TEST_DSN is not set")
+	}
+}
+
+// Create a problem having one test in each of modes, returns its id and check sum
+func testProblem(t *testing.T, modes ...string) (int, string) {
+	t.Helper()
+	sum := utils.RandomString(64)
+	id, err := db.InsertGetId("insert into problems values (null, ?, 0, ?, \"\")", t.Name(), sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pro := &Problem{Id: int(id)}
+	pro.DataInfo.Fullscore = 100
+	for _, mode := range modes {
+		var info *problem.TestdataInfo
+		switch mode {
+		case "pretest":
+			info = &pro.DataInfo.Pretest
+		case "tests":
+			info = &pro.DataInfo.TestdataInfo
+		case "extra":
+			info = &pro.DataInfo.Extra
+		}
+		info.Subtasks = make([]problem.SubtaskInfo, 1)
+		info.Subtasks[0].Tests = make([]problem.TestInfo, 1)
+	}
+	ProblemCache.Set(int(id), pro)
+	t.Cleanup(func() {
+		ProblemCache.Delete(int(id))
+		db.Exec("delete from problems where problem_id=?", id)
+	})
+	return int(id), sum
+}
+
+// Create a submission without judging it
+func testSubmission(t *testing.T, submitter, problem_id, contest_id int) SubmissionBase {
+	t.Helper()
+	id, err := db.InsertGetId("insert into submissions values (null, ?, ?, ?, ?, 0, -1, -1, 0, ?, 0, 0, ?, 0, \"\")",
+		submitter, problem_id, contest_id, Waiting, time.Now(), utils.TimeStamp())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("insert into submission_details values (?, ?, \"\", \"\", \"\", \"\", \"\")", id, []byte(t.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := SubmissionBase{int(id), problem_id, contest_id, submitter}
+	t.Cleanup(func() {
+		db.Exec("delete from judge_queue where submission_id=?", id)
+		SubmDelete(sub)
+	})
+	return sub
+}
+
+// Judge a submission created by testSubmission, and wait until it finishes
+func testJudge(t *testing.T, sub SubmissionBase) {
+	t.Helper()
+	var uuid int64
+	err := db.SelectSingleColumn(&uuid, "select uuid from submissions where submission_id=?", sub.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SubmJudge(sub, false, uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testWait(t, fmt.Sprintf("submission %d to be judged", sub.Id), func() bool { return testSubmStatus(t, sub.Id) == Finished })
+}
+
+func testSubmStatus(t *testing.T, sid int) int {
+	t.Helper()
+	var status int
+	err := db.SelectSingleColumn(&status, "select status from submissions where submission_id=?", sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+/*
+Start a fake judger having the problem data of sums, and a server receiving
+its callbacks. They are stopped when the test finishes.
+*/
+func testJudger(t *testing.T, slots int, plan func(fakejudger.Call) fakejudger.Plan, sums ...string) (*Judger, *fakejudger.Judger) {
+	t.Helper()
+	back := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		sid, _ := strconv.Atoi(query.Get("sid"))
+		result, _ := io.ReadAll(r.Body)
+		err := FinishJudging(query.Get("jid"), sid, query.Get("mode"), r.Header.Get("X-Signature"), result)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, err)
+		}
+	}))
+	old_domain, old_secret := config.Global.BackDomain, config.Global.JudgerSecret
+	config.Global.BackDomain, config.Global.JudgerSecret = back.URL, "secret"
+	fake := fakejudger.New("secret")
+	fake.Plan = plan
+	for _, sum := range sums {
+		fake.Sync(sum)
+	}
+	server := httptest.NewServer(fake)
+	judger, err := JudgerAdd(server.URL, slots, JudgerCaps{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		JudgerRemove(judger.Id)
+		fake.Wait()
+		server.Close()
+		back.Close()
+		config.Global.BackDomain, config.Global.JudgerSecret = old_domain, old_secret
+	})
+	return judger, fake
+}
+
+// Set config.Global.JudgerSecret in the test, so judgers can be added
+func testSecret(t *testing.T) {
+	old := config.Global.JudgerSecret
+	config.Global.JudgerSecret = "secret"
+	t.Cleanup(func() { config.Global.JudgerSecret = old })
+}
+
+// Wait until cond holds, fails after a few seconds
+func testWait(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,369 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+	"yao/db"
+
+	utils "github.com/super-yaoj/yaoj-utils"
+)
+
+/*
+Plagiarism detection: a job compares the scored submissions of a contest or a
+problem pairwise. Sources are normalized by language into tokens without
+comments or whitespace, identifiers and literals are canonicalized, and each
+source is fingerprinted by winnowing hashes of token k-grams. Pairs of different
+submitters to the same problem whose Jaccard similarity of fingerprints reaches
+the threshold of the job are stored for review.
+*/
+
+type PlagiarismJob struct {
+	Id         int        `db:"job_id" json:"job_id"`
+	ContestId  int        `db:"contest_id" json:"contest_id"`
+	ProblemId  int        `db:"problem_id" json:"problem_id"`
+	Threshold  float64    `db:"threshold" json:"threshold"`
+	Status     string     `db:"status" json:"status"`
+	Error      string     `db:"error" json:"error"`
+	CreateTime time.Time  `db:"create_time" json:"create_time"`
+	FinishTime *time.Time `db:"finish_time" json:"finish_time"`
+}
+
+type PlagiarismPair struct {
+	JobId      int     `db:"job_id" json:"job_id"`
+	SubmA      int     `db:"submission_a" json:"submission_a"`
+	SubmB      int     `db:"submission_b" json:"submission_b"`
+	Similarity float64 `db:"similarity" json:"similarity"`
+}
+
+const (
+	PlagRunning  = "running"
+	PlagFinished = "finished"
+	PlagFailed   = "failed"
+	//used when the threshold of a job is not given
+	PlagDefaultThreshold = 0.7
+	plagJobColumns       = "job_id, contest_id, problem_id, threshold, status, error, create_time, finish_time"
+)
+
+// tokens in each k-gram and k-grams in each winnowing window
+const (
+	plagGram   = 5
+	plagWindow = 4
+)
+
+// Jobs interrupted by restarting are marked as failed
+func PlagiarismInit() {
+	_, err := db.Exec("update plagiarism_jobs set status=?, error=?, finish_time=? where status=?", PlagFailed, "interrupted by restart", time.Now(), PlagRunning)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+/*
+Start a job over submissions of contest_id and problem_id, leave either as 0
+to not limit it. Returns the id of the job, which runs in background.
+*/
+func PlagiarismStart(contest_id, problem_id int, threshold float64) (int, error) {
+	if contest_id == 0 && problem_id == 0 {
+		return 0, errors.New("either contest or problem is required")
+	}
+	if threshold <= 0 {
+		threshold = PlagDefaultThreshold
+	}
+	id, err := db.InsertGetId("insert into plagiarism_jobs values (null, ?, ?, ?, ?, \"\", ?, null)", contest_id, problem_id, threshold, PlagRunning, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	job := PlagiarismJob{Id: int(id), ContestId: contest_id, ProblemId: problem_id, Threshold: threshold}
+	go func() {
+		status, msg := PlagFinished, ""
+		err := job.run()
+		if err != nil {
+			fmt.Println(err)
+			status, msg = PlagFailed, err.Error()
+		}
+		_, err = db.Exec("update plagiarism_jobs set status=?, error=?, finish_time=? where job_id=?", status, msg, time.Now(), job.Id)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}()
+	return int(id), nil
+}
+
+func PlagiarismQuery(job_id int) (PlagiarismJob, error) {
+	var ret PlagiarismJob
+	err := db.SelectSingle(&ret, "select "+plagJobColumns+" from plagiarism_jobs where job_id=?", job_id)
+	return ret, err
+}
+
+// Jobs of a contest or a problem, the latest first
+func PlagiarismList(contest_id, problem_id int) ([]PlagiarismJob, error) {
+	ret := []PlagiarismJob{}
+	var err error
+	if contest_id > 0 {
+		err = db.SelectAll(&ret, "select "+plagJobColumns+" from plagiarism_jobs where contest_id=? order by job_id desc", contest_id)
+	} else {
+		err = db.SelectAll(&ret, "select "+plagJobColumns+" from plagiarism_jobs where problem_id=? and contest_id=0 order by job_id desc", problem_id)
+	}
+	return ret, err
+}
+
+// Flagged pairs of a job, the most similar first
+func PlagiarismPairs(job_id int) ([]PlagiarismPair, error) {
+	ret := []PlagiarismPair{}
+	err := db.SelectAll(&ret, "select * from plagiarism_pairs where job_id=? order by similarity desc", job_id)
+	return ret, err
+}
+
+func PlagiarismPairQuery(job_id, subm_a, subm_b int) (PlagiarismPair, error) {
+	if subm_a > subm_b {
+		subm_a, subm_b = subm_b, subm_a
+	}
+	var ret PlagiarismPair
+	err := db.SelectSingle(&ret, "select * from plagiarism_pairs where job_id=? and submission_a=? and submission_b=?", job_id, subm_a, subm_b)
+	return ret, err
+}
+
+type plagSubm struct {
+	Id        int `db:"submission_id"`
+	Submitter int `db:"submitter"`
+	ProblemId int `db:"problem_id"`
+	Language  int `db:"language"`
+	prints    map[uint64]bool
+}
+
+func (job *PlagiarismJob) run() error {
+	query, args := "", []any{}
+	if job.ContestId > 0 {
+		query += " and contest_id=?"
+		args = append(args, job.ContestId)
+	}
+	if job.ProblemId > 0 {
+		query += " and problem_id=?"
+		args = append(args, job.ProblemId)
+	}
+	var subs []plagSubm
+	err := db.SelectAll(&subs, "select submission_id, submitter, problem_id, language from submissions where (score>0 or accepted&?<>0)"+query+" order by submission_id",
+		append([]any{TestsAccepted}, args...)...)
+	if err != nil {
+		return err
+	}
+	probs := make(map[int][]*plagSubm)
+	for i := range subs {
+		sub := &subs[i]
+		if sub.Language < 0 || sub.Language >= len(utils.LangSuf) {
+			continue
+		}
+		sub.prints, err = plagFingerprint(sub.Id, sub.Language)
+		if err != nil {
+			return err
+		}
+		if len(sub.prints) > 0 {
+			probs[sub.ProblemId] = append(probs[sub.ProblemId], sub)
+		}
+	}
+	pairs := []PlagiarismPair{}
+	for _, list := range probs {
+		for i := range list {
+			for j := i + 1; j < len(list); j++ {
+				if list[i].Submitter == list[j].Submitter {
+					continue
+				}
+				sim := plagSimilarity(list[i].prints, list[j].prints)
+				if sim >= job.Threshold {
+					pairs = append(pairs, PlagiarismPair{job.Id, list[i].Id, list[j].Id, sim})
+				}
+			}
+		}
+	}
+	for i := 0; i < len(pairs); i += 100 {
+		batch := pairs[i:utils.Min(i+100, len(pairs))]
+		values, args := []string{}, []any{}
+		for _, pair := range batch {
+			values = append(values, "(?, ?, ?, ?)")
+			args = append(args, pair.JobId, pair.SubmA, pair.SubmB, pair.Similarity)
+		}
+		_, err = db.Exec("insert into plagiarism_pairs values "+strings.Join(values, ", "), args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fingerprints of source files of a submission, files are concatenated by names
+func plagFingerprint(sid int, language int) (map[uint64]bool, error) {
+	files, err := SubmSourceFiles(sid)
+	if err != nil {
+		return nil, err
+	}
+	suffix := utils.LangSuf[language]
+	names := []string{}
+	for name := range files {
+		if strings.HasSuffix(name, suffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	syntax := plagSyntaxOf(suffix)
+	tokens := []string{}
+	for _, name := range names {
+		tokens = append(tokens, plagTokenize(string(files[name]), syntax)...)
+	}
+	return plagWinnow(tokens), nil
+}
+
+// Jaccard similarity of fingerprints, 0 if both are empty, e.g. sources shorter than plagGram tokens
+func plagSimilarity(a, b map[uint64]bool) float64 {
+	common := 0
+	for h := range a {
+		if b[h] {
+			common++
+		}
+	}
+	if len(a)+len(b) == 0 {
+		return 0
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+// Select the minimum hash of k-grams in each window
+func plagWinnow(tokens []string) map[uint64]bool {
+	hashes := []uint64{}
+	for i := 0; i+plagGram <= len(tokens); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(tokens[i:i+plagGram], "\x00")))
+		hashes = append(hashes, h.Sum64())
+	}
+	ret := make(map[uint64]bool)
+	if len(hashes) < plagWindow {
+		//too short to winnow
+		for _, h := range hashes {
+			ret[h] = true
+		}
+	}
+	for i := 0; i+plagWindow <= len(hashes); i++ {
+		min := hashes[i]
+		for _, h := range hashes[i+1 : i+plagWindow] {
+			if h <= min {
+				min = h
+			}
+		}
+		ret[min] = true
+	}
+	return ret
+}
+
+type plagSyntax struct {
+	lineComment  string
+	blockComment bool
+	//quotes of string literals
+	quotes       string
+	tripleQuotes bool
+	keywords     map[string]bool
+}
+
+func plagKeywords(words string) map[string]bool {
+	ret := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		ret[word] = true
+	}
+	return ret
+}
+
+var plagSyntaxes = map[string]*plagSyntax{
+	".c": {"//", true, "\"'", false, plagKeywords(`auto break case char const continue default do double else enum extern
+		float for goto if inline int long register restrict return short signed sizeof static struct switch
+		typedef union unsigned void volatile while include define`)},
+	".cpp": {"//", true, "\"'", false, plagKeywords(`auto break case char const continue default do double else enum extern
+		float for goto if inline int long register return short signed sizeof static struct switch typedef
+		union unsigned void volatile while bool catch class constexpr delete explicit false friend mutable
+		namespace new operator private protected public template this throw true try typename using virtual
+		include define std`)},
+	".java": {"//", true, "\"'", false, plagKeywords(`abstract boolean break byte case catch char class continue default do
+		double else enum extends final finally float for if implements import instanceof int interface long
+		new package private protected public return short static super switch this throw throws try void
+		while true false null`)},
+	".go": {"//", true, "\"'`", false, plagKeywords(`break case chan const continue default defer else fallthrough for func
+		go goto if import interface map package range return select struct switch type var true false nil`)},
+	".py": {"#", false, "\"'", true, plagKeywords(`and as assert break class continue def del elif else except False
+		finally for from global if import in is lambda None nonlocal not or pass raise return True try while
+		with yield`)},
+}
+
+func plagSyntaxOf(suffix string) *plagSyntax {
+	if syntax, ok := plagSyntaxes[suffix]; ok {
+		return syntax
+	}
+	return plagSyntaxes[".c"]
+}
+
+func plagIdentByte(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+/*
+Split a source into tokens, with comments and whitespace removed. Identifiers
+other than keywords become "V", string literals "S" and numbers "N", so that
+renaming doesn't change the tokens.
+*/
+func plagTokenize(src string, syntax *plagSyntax) []string {
+	tokens := []string{}
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			i++
+		case strings.HasPrefix(src[i:], syntax.lineComment):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case syntax.blockComment && strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				i = len(src)
+			} else {
+				i += end + 4
+			}
+		case strings.IndexByte(syntax.quotes, c) >= 0:
+			triple := strings.Repeat(string(c), 3)
+			if syntax.tripleQuotes && strings.HasPrefix(src[i:], triple) {
+				end := strings.Index(src[i+3:], triple)
+				if end < 0 {
+					i = len(src)
+				} else {
+					i += end + 6
+				}
+			} else {
+				i++
+				for i < len(src) && src[i] != c {
+					if src[i] == '\\' && c != '`' {
+						i++
+					}
+					i++
+				}
+				i++
+			}
+			tokens = append(tokens, "S")
+		case plagIdentByte(c, true):
+			j := i
+			for j < len(src) && plagIdentByte(src[j], false) {
+				j++
+			}
+			tokens = append(tokens, utils.If(syntax.keywords[src[i:j]], src[i:j], "V"))
+			i = j
+		case c >= '0' && c <= '9':
+			for i < len(src) && (plagIdentByte(src[i], false) || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, "N")
+		default:
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlagTokenize(t *testing.T) {
+	tests := []struct {
+		suffix string
+		src    string
+		want   string
+	}{
+		{".cpp", "int main() { return 0; }", "int V ( ) { return N ; }"},
+		{".cpp", "// comment\nx = \"a\\\"b\" + 'c'; /* block\n */ y += 1.5e3;", "V = S + S ; V + = N ;"},
+		{".cpp", "a /* unterminated", "V"},
+		{".py", "def f(x):  # comment\n    return '''doc\n''' + x", "def V ( V ) : return S + V"},
+		{".go", "s := `raw\\` + 2", "V : = S + N"},
+		{".unknown", "while (i--) {}", "while ( V - - ) { }"},
+	}
+	for _, test := range tests {
+		got := plagTokenize(test.src, plagSyntaxOf(test.suffix))
+		if want := strings.Fields(test.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("plagTokenize(%q) = %q, want %q", test.src, got, want)
+		}
+	}
+}
+
+func TestPlagWinnow(t *testing.T) {
+	tokens := strings.Fields("a b c d e f g h i j k l m n o p")
+	tests := []struct {
+		tokens []string
+		//number of hashes at least and at most
+		min, max int
+	}{
+		{nil, 0, 0},
+		{tokens[:plagGram-1], 0, 0},
+		{tokens[:plagGram], 1, 1},
+		{tokens[:plagGram+plagWindow-2], 1, plagWindow - 1},
+		//at least one hash in each window
+		{tokens, (len(tokens) - plagGram + 1) / plagWindow, len(tokens) - plagGram + 1},
+	}
+	for _, test := range tests {
+		got := plagWinnow(test.tokens)
+		if len(got) < test.min || len(got) > test.max {
+			t.Errorf("plagWinnow(%q) has %d hashes, want %d to %d", test.tokens, len(got), test.min, test.max)
+		}
+	}
+	if !reflect.DeepEqual(plagWinnow(tokens), plagWinnow(append([]string{}, tokens...))) {
+		t.Error("fingerprints of the same tokens differ")
+	}
+}
+
+func TestPlagSimilarity(t *testing.T) {
+	const gcd = `#include <cstdio>
+// greatest common divisor
+int gcd(int a, int b) { return b == 0 ? a : gcd(b, a % b); }
+int main() {
+	int n, ans = 0;
+	scanf("%d", &n);
+	for (int i = 1; i <= n; i++) {
+		int x;
+		scanf("%d", &x);
+		ans = gcd(ans, x);
+	}
+	printf("%d\n", ans);
+	return 0;
+}`
+	renamed := `#include <cstdio>
+/* renamed everything */
+int euclid(int p, int q) { return q == 0 ? p : euclid(q, p % q); }
+int main() {
+	int cnt, res = 0;
+	scanf("%d", &cnt);
+	for (int k = 1; k <= cnt; k++) {
+		int val;
+		scanf("%d", &val);   // read
+		res = euclid(res, val);
+	}
+	printf("%d\n", res);
+	return 0;
+}`
+	unrelated := `#include <vector>
+#include <queue>
+std::vector<int> g[100005];
+bool vis[100005];
+void bfs(int s) {
+	std::queue<int> q;
+	q.push(s);
+	vis[s] = true;
+	while (!q.empty()) {
+		int u = q.front();
+		q.pop();
+		for (auto v : g[u]) if (!vis[v]) vis[v] = true, q.push(v);
+	}
+}`
+	fingerprint := func(src string) map[uint64]bool {
+		return plagWinnow(plagTokenize(src, plagSyntaxOf(".cpp")))
+	}
+	tests := []struct {
+		name     string
+		a, b     string
+		min, max float64
+	}{
+		{"identical", gcd, gcd, 1, 1},
+		{"renamed identifiers", gcd, renamed, 1, 1},
+		{"unrelated", gcd, unrelated, 0, 0.1},
+		{"shorter than k", "int x;", "int", 0, 0},
+		{"empty", "", "", 0, 0},
+	}
+	for _, test := range tests {
+		got := plagSimilarity(fingerprint(test.a), fingerprint(test.b))
+		if math.IsNaN(got) || got < test.min || got > test.max {
+			t.Errorf("%s: similarity %v, want %v to %v", test.name, got, test.min, test.max)
+		}
+	}
+}
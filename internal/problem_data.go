@@ -103,6 +103,7 @@ func ProbSetCache(problem_id int, pro problem.Problem) {
 		HasSample:    utils.FileExists(ProbGetSampleZip(problem_id)),
 		TimeLimit:    utils.AtoiDefault(pro.Data().Statement["_tl"], -1),
 		MemoryLimit:  utils.AtoiDefault(pro.Data().Statement["_ml"], -1),
+		JudgerTags:   judgerTagsParse(pro.Data().Statement["_judger_tags"]),
 	})
 }
 
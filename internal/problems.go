@@ -39,6 +39,8 @@ type Problem struct {
 	//01-string denotes which files can be downloaded
 	AllowDown  string     `db:"allow_down" json:"allow_down"`
 	SubmConfig SubmConfig `json:"subm_config"`
+	//judgers must have all these tags to judge the problem, set by the _judger_tags statement
+	JudgerTags []string `json:"judger_tags"`
 	//Could only be seen by admins
 	DataInfo problem.DataInfo `json:"data"`
 }
@@ -153,18 +155,30 @@ func ProbExists(problem_id int) bool {
 func ProbRejudge(problem_id int) error {
 	current := utils.TimeStamp()
 	var sub []SubmissionBase
-	err := db.SelectAll(&sub, "select submission_id, contest_id from submissions where problem_id=?", problem_id)
+	//submitters are needed to schedule the entries fairly with others
+	err := db.SelectAll(&sub, "select submission_id, problem_id, contest_id, submitter from submissions where problem_id=?", problem_id)
 	if err != nil {
 		return err
 	}
 	//update uuid to current time-stamp
-	_, err = db.Exec("update submissions set uuid=?, status=0 where problem_id=?", current, problem_id)
+	_, err = db.Exec("update submissions set uuid=?, status=0, verdict=\"\" where problem_id=?", current, problem_id)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("update submission_details set judge_error=\"\" where submission_id in (select submission_id from submissions where problem_id=?)", problem_id)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("delete from submission_verdicts where submission_id in (select submission_id from submissions where problem_id=?)", problem_id)
 	if err != nil {
 		return err
 	}
 	Register("OnProbRejudge", problem_id)
 	for _, i := range sub {
-		SubmJudge(i, true, current)
+		err = SubmJudge(i, true, current)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
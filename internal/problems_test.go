@@ -0,0 +1,41 @@
+package internal
+
+import "testing"
+
+func TestProbRejudgeFair(t *testing.T) {
+	testDB(t)
+	testQueue(t)
+	pid, _ := testProblem(t, "tests")
+	subs := []SubmissionBase{testSubmission(t, 1, pid, 0), testSubmission(t, 1, pid, 0), testSubmission(t, 1, pid, 0), testSubmission(t, 2, pid, 0)}
+	err := ProbRejudge(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a live submission of another problem goes first
+	other, _ := testProblem(t, "tests")
+	live := testSubmission(t, 3, other, 0)
+	err = SubmJudge(live, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := []int{}
+	for _, entry := range waitingList.List() {
+		if entry.mode == "tests" {
+			if entry.submitter == 0 {
+				t.Fatalf("entry of submission %d has no submitter", entry.sid)
+			}
+			order = append(order, entry.sid)
+		}
+	}
+	//submitter 2 doesn't wait for all submissions of submitter 1
+	want := []int{live.Id, subs[0].Id, subs[3].Id, subs[1].Id, subs[2].Id}
+	if len(order) != len(want) {
+		t.Fatalf("order of entries %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order of entries %v, want %v", order, want)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"yao/db"
+
+	jsoniter "github.com/json-iterator/go"
+	utils "github.com/super-yaoj/yaoj-utils"
+)
+
+/*
+Rejudge jobs: submissions selected by a SubmFilter are rejudged together, and
+each of them is an item of the job. An item is done or failed when AfterSubmJudge
+of its submission is registered, failed means an internal error.
+
+Results of submissions are snapshotted when the job starts, and compared with
+the new ones in RejudgeReport.
+
+Cancelling a job bumps uuids of its pending submissions, so that their entries
+in the judging queue are skipped, and restores their results from the snapshots.
+A job that fails to start is failed in the same way.
+*/
+
+type RejudgeJob struct {
+	Id      int `db:"job_id" json:"job_id"`
+	Creator int `db:"creator" json:"creator"`
+	//SubmFilter in json
+	Filter     string     `db:"filter" json:"filter"`
+	Queued     int        `db:"queued" json:"queued"`
+	Done       int        `db:"done" json:"done"`
+	Failed     int        `db:"failed" json:"failed"`
+	Cancelled  int        `db:"cancelled" json:"cancelled"`
+	Status     string     `db:"status" json:"status"`
+	CreateTime time.Time  `db:"create_time" json:"create_time"`
+	FinishTime *time.Time `db:"finish_time" json:"finish_time"`
+}
+
+const (
+	RejudgeRunning    = "running"
+	RejudgeFinished   = "finished"
+	RejudgeCancelled  = "cancelled"
+	RejudgeFailed     = "failed"
+	rejudgeJobColumns = "job_id, creator, filter, queued, done, failed, cancelled, status, create_time, finish_time"
+)
+
+// states of items
+const (
+	rejudgePending = iota
+	rejudgeDone
+	rejudgeFailed
+	rejudgeCancelled
+)
+
+func init() {
+	AfterSubmJudge(func(sb SubmissionBase) {
+		err := rejudgeItemFinish(sb.Id, false)
+		if err != nil {
+			fmt.Println(err)
+		}
+	})
+	AfterSubmDelete(func(sb SubmissionBase) {
+		err := rejudgeItemFinish(sb.Id, true)
+		if err != nil {
+			fmt.Println(err)
+		}
+	})
+}
+
+// Rejudge all submissions matching filter, returns the id of the job
+func RejudgeStart(creator int, filter SubmFilter) (int, error) {
+	//an empty filter would rejudge every submission
+	if filter.Submitter == 0 && filter.ProblemId == 0 && filter.ContestId == 0 && filter.After == nil && filter.Before == nil {
+		return 0, errors.New("the filter should have a problem, contest, submitter or time range")
+	}
+	subs, err := SubmSelect(filter)
+	if err != nil {
+		return 0, err
+	}
+	if len(subs) == 0 {
+		return 0, errors.New("no submissions match the filter")
+	}
+	js, err := jsoniter.Marshal(filter)
+	if err != nil {
+		return 0, err
+	}
+	current := utils.TimeStamp()
+	id, err := db.InsertGetId("insert into rejudge_jobs values (null, ?, ?, ?, 0, 0, 0, ?, ?, null)", creator, string(js), len(subs), RejudgeRunning, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(subs); i += 100 {
+		batch := subs[i:utils.Min(i+100, len(subs))]
+		sids := []int{}
+		for _, sub := range batch {
+			sids = append(sids, sub.Id)
+		}
+		err = rejudgeReset(int(id), sids, current)
+		if err != nil {
+			return 0, rejudgeAbort(int(id), subs, current, err)
+		}
+	}
+	for i, sub := range subs {
+		Register("OnSubmRejudge", sub)
+		err = SubmJudge(sub, true, current)
+		if err != nil {
+			return 0, rejudgeAbort(int(id), subs[i:], current, err)
+		}
+	}
+	return int(id), nil
+}
+
+// Snapshot results of submissions sids as items of a job, then reset them
+func rejudgeReset(job_id int, sids []int, uuid int64) error {
+	sid_str := utils.JoinArray(sids)
+	_, err := db.Exec("insert into rejudge_job_items select ?, submission_id, ?, ?, score, accepted, time, memory, verdict from submissions where submission_id in ("+sid_str+")",
+		job_id, uuid, rejudgePending)
+	if err != nil {
+		return err
+	}
+	//update uuid to cancel other entries in the judging queue
+	_, err = db.Exec("update submissions set uuid=?, status=0, accepted=0, verdict=\"\" where submission_id in ("+sid_str+")", uuid)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("update submission_details set judge_error=\"\" where submission_id in (" + sid_str + ")")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("delete from submission_verdicts where submission_id in (" + sid_str + ")")
+	return err
+}
+
+/*
+Fail a job which is not fully started because of cause. Submissions in subs
+which are reset but not queued are restored, and the job is finished once its
+queued items are judged. Returns cause.
+*/
+func rejudgeAbort(job_id int, subs []SubmissionBase, uuid int64, cause error) error {
+	fmt.Println("rejudge job", job_id, "failed to start:", cause)
+	_, err := db.Exec("update rejudge_jobs set status=?, queued=(select count(*) from rejudge_job_items where job_id=?) where job_id=?",
+		RejudgeFailed, job_id, job_id)
+	if err != nil {
+		fmt.Println(err)
+		return cause
+	}
+	current := utils.TimeStamp()
+	for _, sub := range subs {
+		_, err = db.Exec("update rejudge_job_items set state=? where job_id=? and submission_id=? and state=?", rejudgeFailed, job_id, sub.Id, rejudgePending)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		//only submissions reset by this job, and not queued again by others
+		bumped, err := db.ExecGetAffected("update submissions set uuid=? where submission_id=? and uuid=?", current, sub.Id, uuid)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if bumped > 0 {
+			err = rejudgeRestore(job_id, sub.Id)
+			if err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+	err = rejudgeCount(job_id)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return cause
+}
+
+func RejudgeQuery(job_id int) (RejudgeJob, error) {
+	var ret RejudgeJob
+	err := db.SelectSingle(&ret, "select "+rejudgeJobColumns+" from rejudge_jobs where job_id=?", job_id)
+	return ret, err
+}
+
+// Recent jobs, the latest first. Jobs of all creators are listed if creator is 0.
+func RejudgeList(creator int, limit int) ([]RejudgeJob, error) {
+	ret := []RejudgeJob{}
+	var err error
+	if creator > 0 {
+		err = db.SelectAll(&ret, "select "+rejudgeJobColumns+" from rejudge_jobs where creator=? order by job_id desc limit ?", creator, limit)
+	} else {
+		err = db.SelectAll(&ret, "select "+rejudgeJobColumns+" from rejudge_jobs order by job_id desc limit ?", limit)
+	}
+	return ret, err
+}
+
+// Cancel the pending submissions of a job
+func RejudgeCancel(job_id int) error {
+	var items []struct {
+		Sid  int   `db:"submission_id"`
+		Uuid int64 `db:"uuid"`
+	}
+	err := db.SelectAll(&items, "select submission_id, uuid from rejudge_job_items where job_id=? and state=?", job_id, rejudgePending)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("update rejudge_jobs set status=? where job_id=? and status=?", RejudgeCancelled, job_id, RejudgeRunning)
+	if err != nil {
+		return err
+	}
+	current := utils.TimeStamp()
+	for _, item := range items {
+		_, err = db.Exec("update rejudge_job_items set state=? where job_id=? and submission_id=? and state=?", rejudgeCancelled, job_id, item.Sid, rejudgePending)
+		if err != nil {
+			return err
+		}
+		//submissions queued again by others are left to them
+		bumped, err := db.ExecGetAffected("update submissions set uuid=? where submission_id=? and uuid=?", current, item.Sid, item.Uuid)
+		if err != nil {
+			return err
+		}
+		if bumped > 0 {
+			err = rejudgeRestore(job_id, item.Sid)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return rejudgeCount(job_id)
+}
+
+// Restore the result of a submission snapshotted by the job, verdicts of testcases are parsed from the stored results
+func rejudgeRestore(job_id int, sid int) error {
+	sm_update_mutex.Lock()
+	defer sm_update_mutex.Unlock()
+	_, err := db.Exec(`update submissions s join rejudge_job_items i on i.submission_id=s.submission_id
+		set s.status=if(i.old_verdict=?, ?, ?), s.accepted=i.old_accepted, s.score=i.old_score, s.time=i.old_time, s.memory=i.old_memory, s.verdict=i.old_verdict
+		where i.job_id=? and s.submission_id=?`, VerdictIE, InternalError, Finished, job_id, sid)
+	if err != nil {
+		return err
+	}
+	var details SubmissionDetails
+	err = db.SelectSingle(&details, "select content_preview, result, pretest_result, extra_result, judge_error from submission_details where submission_id=?", sid)
+	if err != nil {
+		return err
+	}
+	results := map[string]string{"pretest": details.PretestResult, "tests": details.Result, "extra": details.ExtraResult}
+	for mode, result := range results {
+		res, err := JudgeResultParse([]byte(result))
+		if err != nil {
+			continue
+		}
+		err = submSaveVerdicts(sid, mode, res)
+		if err != nil {
+			return err
+		}
+	}
+	return submJudged(sid, "tests")
+}
+
+// Finish pending items of a submission, items are cancelled if the submission is deleted
+func rejudgeItemFinish(sid int, deleted bool) error {
+	jobs, err := db.SelectInts("select job_id from rejudge_job_items where submission_id=? and state=?", sid, rejudgePending)
+	if err != nil || len(jobs) == 0 {
+		return err
+	}
+	state := rejudgeCancelled
+	if !deleted {
+		var status int
+		err = db.SelectSingleColumn(&status, "select status from submissions where submission_id=?", sid)
+		if err != nil {
+			return err
+		}
+		state = utils.If(status < 0, rejudgeFailed, rejudgeDone)
+	}
+	_, err = db.Exec("update rejudge_job_items set state=? where submission_id=? and state=?", state, sid, rejudgePending)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		err = rejudgeCount(job)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update counts of a job, and finish it if no item is pending
+func rejudgeCount(job_id int) error {
+	count := "(select count(*) from rejudge_job_items where job_id=? and state=?)"
+	_, err := db.Exec("update rejudge_jobs set done="+count+", failed="+count+", cancelled="+count+" where job_id=?",
+		job_id, rejudgeDone, job_id, rejudgeFailed, job_id, rejudgeCancelled, job_id)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("update rejudge_jobs set status=if(status=?, ?, status), finish_time=? where job_id=? and finish_time is null and done+failed+cancelled>=queued",
+		RejudgeRunning, RejudgeFinished, time.Now(), job_id)
+	return err
+}
+
+// A submission whose score or verdict is changed by a rejudge job
+type RejudgeChange struct {
+	SubmissionBase
+	OldScore    float64 `db:"old_score" json:"old_score"`
+	Score       float64 `db:"score" json:"score"`
+	OldVerdict  string  `db:"old_verdict" json:"old_verdict"`
+	Verdict     string  `db:"verdict" json:"verdict"`
+	OldAccepted int     `db:"old_accepted" json:"old_accepted"`
+	Accepted    int     `db:"accepted" json:"accepted"`
+	OldTime     int     `db:"old_time" json:"old_time"`
+	Time        int     `db:"time" json:"time"`
+	OldMemory   int     `db:"old_memory" json:"old_memory"`
+	Memory      int     `db:"memory" json:"memory"`
+}
+
+// A participant whose position in the standing of a contest is changed by a rejudge job
+type RejudgeShift struct {
+	ContestId int     `json:"contest_id"`
+	UserId    int     `json:"user_id"`
+	OldRank   int     `json:"old_rank"`
+	Rank      int     `json:"rank"`
+	OldScore  float64 `json:"old_score"`
+	Score     float64 `json:"score"`
+}
+
+type RejudgeReport struct {
+	Changes []RejudgeChange `json:"changes"`
+	Shifts  []RejudgeShift  `json:"shifts"`
+}
+
+/*
+Report of a finished or cancelled job. Standings are ranked by the total score
+of the last submissions to each problem, ties are broken by the total penalty.
+Standings of finished contests are saved when they finish, so shifts of them
+are how they would change but they are not updated.
+*/
+func RejudgeReportOf(job_id int) (*RejudgeReport, error) {
+	job, err := RejudgeQuery(job_id)
+	if err != nil {
+		return nil, err
+	}
+	if job.FinishTime == nil {
+		return nil, errors.New("the job is still running")
+	}
+	report := &RejudgeReport{Changes: []RejudgeChange{}, Shifts: []RejudgeShift{}}
+	err = db.SelectAll(&report.Changes, `select s.submission_id, s.problem_id, s.contest_id, s.submitter,
+		i.old_score, s.score, ifnull(i.old_verdict, "") as old_verdict, ifnull(s.verdict, "") as verdict,
+		i.old_accepted, s.accepted, i.old_time, s.time, i.old_memory, s.memory
+		from rejudge_job_items as i join submissions as s on i.submission_id=s.submission_id
+		where i.job_id=? and i.state in (?, ?) and (i.old_score<>s.score or i.old_accepted<>s.accepted
+			or (i.old_verdict is not null and not (i.old_verdict<=>s.verdict)))
+		order by s.submission_id`, job_id, rejudgeDone, rejudgeFailed)
+	if err != nil {
+		return nil, err
+	}
+	contests := []int{}
+	for _, change := range report.Changes {
+		if change.ContestId > 0 && !utils.HasElement(contests, change.ContestId) {
+			contests = append(contests, change.ContestId)
+		}
+	}
+	for _, contest_id := range contests {
+		shifts, err := rejudgeShifts(job_id, contest_id)
+		if err != nil {
+			return nil, err
+		}
+		report.Shifts = append(report.Shifts, shifts...)
+	}
+	return report, nil
+}
+
+func rejudgeShifts(job_id, contest_id int) ([]RejudgeShift, error) {
+	contest, err := CTQuery(contest_id, -1)
+	if err != nil {
+		return nil, err
+	}
+	probs, err := CTGetProblems(contest_id)
+	if err != nil {
+		return nil, err
+	}
+	in_contest := make(map[int]bool)
+	for _, prob := range probs {
+		in_contest[prob.Id] = true
+	}
+	var subs []standingSubm
+	err = db.SelectAll(&subs, "select "+standingCols+" from submissions where contest_id=? order by submission_id", contest_id)
+	if err != nil {
+		return nil, err
+	}
+	var items []struct {
+		Sid      int     `db:"submission_id"`
+		OldScore float64 `db:"old_score"`
+	}
+	err = db.SelectAll(&items, "select submission_id, old_score from rejudge_job_items where job_id=? and state in (?, ?)", job_id, rejudgeDone, rejudgeFailed)
+	if err != nil {
+		return nil, err
+	}
+	old_scores := make(map[int]float64)
+	for _, item := range items {
+		old_scores[item.Sid] = item.OldScore
+	}
+
+	//the last submission of each user to each problem counts
+	last := make(map[[2]int]*standingSubm)
+	for i := range subs {
+		if in_contest[subs[i].Problem] {
+			last[[2]int{subs[i].Submitter, subs[i].Problem}] = &subs[i]
+		}
+	}
+	totals := make(map[int]*rejudgeTotal)
+	for key, sub := range last {
+		t, ok := totals[key[0]]
+		if !ok {
+			t = &rejudgeTotal{user: key[0]}
+			totals[key[0]] = t
+		}
+		old, ok := old_scores[sub.Id]
+		if !ok {
+			old = sub.Score
+		}
+		t.scores[0] += old
+		t.scores[1] += sub.Score
+		t.penalty += sub.Penalty.Sub(contest.StartTime)
+	}
+	list := []*rejudgeTotal{}
+	for _, t := range totals {
+		list = append(list, t)
+	}
+	old_ranks, ranks := rejudgeRank(list, 0), rejudgeRank(list, 1)
+	shifts := []RejudgeShift{}
+	for _, t := range list {
+		if old_ranks[t.user] != ranks[t.user] {
+			shifts = append(shifts, RejudgeShift{contest_id, t.user, old_ranks[t.user], ranks[t.user], t.scores[0], t.scores[1]})
+		}
+	}
+	sort.Slice(shifts, func(i, j int) bool { return shifts[i].Rank < shifts[j].Rank })
+	return shifts, nil
+}
+
+type rejudgeTotal struct {
+	user int
+	//total scores before and after rejudging
+	scores  [2]float64
+	penalty time.Duration
+}
+
+// Ranks of users by scores[k], users with the same score and penalty share the same rank
+func rejudgeRank(list []*rejudgeTotal, k int) map[int]int {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].scores[k] != list[j].scores[k] {
+			return list[i].scores[k] > list[j].scores[k]
+		}
+		return list[i].penalty < list[j].penalty
+	})
+	ranks := make(map[int]int)
+	for i, t := range list {
+		if i > 0 && t.scores[k] == list[i-1].scores[k] && t.penalty == list[i-1].penalty {
+			ranks[t.user] = ranks[list[i-1].user]
+		} else {
+			ranks[t.user] = i + 1
+		}
+	}
+	return ranks
+}
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"yao/db"
+	"yao/fakejudger"
+)
+
+func TestRejudgeJob(t *testing.T) {
+	testDB(t)
+	testQueue(t)
+	pid, sum := testProblem(t, "tests")
+	lock, wrong := sync.Mutex{}, false
+	testJudger(t, 2, func(call fakejudger.Call) fakejudger.Plan {
+		lock.Lock()
+		defer lock.Unlock()
+		result := fakejudger.Accepted(2, 100)
+		if wrong {
+			result.Subtask[0].Testcase[1] = fakejudger.Testcase{Title: "Wrong Answer", Time: 1, Memory: 1024}
+		}
+		return fakejudger.Plan{Result: result}
+	}, sum)
+	subs := []SubmissionBase{testSubmission(t, 1, pid, 0), testSubmission(t, 2, pid, 0), testSubmission(t, 3, pid, 0)}
+	for _, sub := range subs {
+		testJudge(t, sub)
+	}
+
+	lock.Lock()
+	wrong = true
+	lock.Unlock()
+	id, err := RejudgeStart(1, SubmFilter{ProblemId: pid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job RejudgeJob
+	testWait(t, "the job to finish", func() bool {
+		job, err = RejudgeQuery(id)
+		return err == nil && job.Status != RejudgeRunning
+	})
+	if job.Status != RejudgeFinished || job.Queued != len(subs) || job.Done != len(subs) || job.Failed != 0 || job.FinishTime == nil {
+		t.Fatalf("unexpected job %+v", job)
+	}
+	report, err := RejudgeReportOf(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Changes) != len(subs) {
+		t.Fatalf("%d changes, want %d", len(report.Changes), len(subs))
+	}
+	for i, change := range report.Changes {
+		if change.Id != subs[i].Id || change.OldScore != 100 || change.Score != 50 || change.OldVerdict != VerdictAC || change.Verdict != VerdictWA {
+			t.Errorf("unexpected change %+v", change)
+		}
+	}
+}
+
+func TestRejudgeEmptyFilter(t *testing.T) {
+	//language alone still matches almost everything
+	lang := 0
+	_, err := RejudgeStart(1, SubmFilter{Language: &lang})
+	if err == nil {
+		t.Fatal("a job without a problem, contest, submitter or time range is started")
+	}
+}
+
+func TestRejudgeCancel(t *testing.T) {
+	testDB(t)
+	testQueue(t)
+	pid, _ := testProblem(t, "tests")
+	sub := testSubmission(t, 1, pid, 0)
+	_, err := db.Exec("update submissions set status=?, accepted=?, score=100, verdict=? where submission_id=?", Finished, TestsAccepted, VerdictAC, sub.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//there's no judger, so the submission stays pending
+	id, err := RejudgeStart(1, SubmFilter{ProblemId: pid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RejudgeCancel(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored struct {
+		Status   int     `db:"status"`
+		Accepted int     `db:"accepted"`
+		Score    float64 `db:"score"`
+		Verdict  string  `db:"verdict"`
+	}
+	err = db.SelectSingle(&restored, "select status, accepted, score, verdict from submissions where submission_id=?", sub.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Status != Finished || restored.Accepted != TestsAccepted || restored.Score != 100 || restored.Verdict != VerdictAC {
+		t.Fatalf("submission isn't restored: %+v", restored)
+	}
+	job, err := RejudgeQuery(id)
+	if err != nil || job.Status != RejudgeCancelled || job.Cancelled != 1 {
+		t.Fatalf("unexpected job %+v, %v", job, err)
+	}
+}
+
+func TestRejudgeReportLegacy(t *testing.T) {
+	testDB(t)
+	pid, _ := testProblem(t, "tests")
+	same, accepted := testSubmission(t, 1, pid, 0), testSubmission(t, 2, pid, 0)
+	_, err := db.Exec("update submissions set status=?, score=100, verdict=? where submission_id in (?, ?)", Finished, VerdictAC, same.Id, accepted.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("update submissions set accepted=? where submission_id=?", TestsAccepted, accepted.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := db.InsertGetId("insert into rejudge_jobs values (null, 1, \"{}\", 2, 2, 0, 0, ?, ?, ?)", RejudgeFinished, time.Now(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec("delete from rejudge_job_items where job_id=?", id)
+		db.Exec("delete from rejudge_jobs where job_id=?", id)
+	})
+	//snapshots taken before verdicts were stored have null verdicts
+	_, err = db.Exec("insert into rejudge_job_items values (?, ?, 0, ?, 100, 0, 0, 0, null), (?, ?, 0, ?, 100, 0, 0, 0, null)",
+		id, same.Id, rejudgeDone, id, accepted.Id, rejudgeDone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := RejudgeReportOf(int(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Changes) != 1 || report.Changes[0].Id != accepted.Id {
+		t.Fatalf("unexpected changes %+v, want only submission %d", report.Changes, accepted.Id)
+	}
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"fmt"
+	"sync"
+	"yao/db"
+)
+
+/*
+Changes of submissions are pushed to watchers, who watch either a submission
+or all submissions of a submitter. Events are fed by the hooks AfterSubmCreate,
+OnSubmJudging, AfterSubmModeJudge, AfterSubmJudge and OnSubmRejudge.
+
+Events carry the whole Submission, visibility should be checked by watchers.
+*/
+
+type SubmEvent struct {
+	//one of "create", "judging", "judged", "finished" and "rejudge"
+	Event string `json:"event"`
+	//mode that starts or finishes judging, empty for other events
+	Mode       string     `json:"mode,omitempty"`
+	Submission Submission `json:"submission"`
+}
+
+type submWatch struct {
+	sid       int
+	submitter int
+}
+
+var (
+	submWatchers  = make(map[chan SubmEvent]submWatch)
+	submWatchLock = sync.Mutex{}
+)
+
+// 钩子：某个评测模式开始评测时
+func OnSubmJudging(f func(sid int, mode string)) {
+	Listen("OnSubmJudging", f)
+}
+
+// 钩子：某个评测模式的结果写入数据库后（包括评测失败）
+func AfterSubmModeJudge(f func(sid int, mode string)) {
+	Listen("AfterSubmModeJudge", f)
+}
+
+func init() {
+	AfterSubmCreate(func(sub SubmissionBase) { submNotify(sub.Id, "create", "") })
+	OnSubmJudging(func(sid int, mode string) { submNotify(sid, "judging", mode) })
+	AfterSubmModeJudge(func(sid int, mode string) { submNotify(sid, "judged", mode) })
+	AfterSubmJudge(func(sub SubmissionBase) { submNotify(sub.Id, "finished", "") })
+	OnSubmRejudge(func(sub SubmissionBase) { submNotify(sub.Id, "rejudge", "") })
+	OnProbRejudge(func(problem_id int) {
+		if !submWatched() {
+			return
+		}
+		sids, err := db.SelectInts("select submission_id from submissions where problem_id=?", problem_id)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		for _, sid := range sids {
+			submNotify(sid, "rejudge", "")
+		}
+	})
+}
+
+/*
+Watch changes of submission sid if sid > 0, or of all submissions by submitter
+otherwise. Slow watchers miss events, call cancel when done watching.
+*/
+func SubmWatch(sid int, submitter int) (ch <-chan SubmEvent, cancel func()) {
+	submWatchLock.Lock()
+	defer submWatchLock.Unlock()
+	c := make(chan SubmEvent, 100)
+	if sid > 0 {
+		submitter = 0
+	}
+	submWatchers[c] = submWatch{sid, submitter}
+	return c, func() {
+		submWatchLock.Lock()
+		defer submWatchLock.Unlock()
+		delete(submWatchers, c)
+	}
+}
+
+func submWatched() bool {
+	submWatchLock.Lock()
+	defer submWatchLock.Unlock()
+	return len(submWatchers) > 0
+}
+
+func submNotify(sid int, event string, mode string) {
+	if !submWatched() {
+		return
+	}
+	subs := SubmListByIds([]int{sid})
+	if len(subs) == 0 || subs[0].Id == 0 {
+		return
+	}
+	sub := subs[0]
+	ev := SubmEvent{event, mode, sub}
+	submWatchLock.Lock()
+	defer submWatchLock.Unlock()
+	for c, w := range submWatchers {
+		if w.sid != sub.Id && w.submitter != sub.Submitter {
+			continue
+		}
+		select {
+		case c <- ev:
+		default:
+		}
+	}
+}
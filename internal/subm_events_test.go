@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubmWatch(t *testing.T) {
+	testDB(t)
+	testQueue(t)
+	pid, sum := testProblem(t, "pretest", "tests")
+	testJudger(t, 1, nil, sum)
+	subs := []SubmissionBase{testSubmission(t, 10, pid, 0), testSubmission(t, 10, pid, 0)}
+	ch, cancel := SubmWatch(0, 10)
+	defer cancel()
+	for _, sub := range subs {
+		testJudge(t, sub)
+	}
+
+	//events of each submission
+	judging, judged, finished := map[int]int{}, map[int]int{}, map[int]int{}
+	done := func() bool {
+		for _, sub := range subs {
+			if judging[sub.Id] < 2 || judged[sub.Id] < 3 || finished[sub.Id] < 1 {
+				return false
+			}
+		}
+		return true
+	}
+	timeout := time.After(10 * time.Second)
+	for !done() {
+		select {
+		case ev := <-ch:
+			switch ev.Event {
+			case "judging":
+				judging[ev.Submission.Id]++
+			case "judged":
+				judged[ev.Submission.Id]++
+			case "finished":
+				finished[ev.Submission.Id]++
+			}
+		case <-timeout:
+			t.Fatalf("missing events: judging %v, judged %v, finished %v", judging, judged, finished)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package internal
 
 import (
 	"fmt"
+	"path"
 	"sort"
+	"strings"
 	"sync"
 	"time"
+	"yao/config"
 	"yao/db"
 
 	jsoniter "github.com/json-iterator/go"
@@ -43,6 +46,7 @@ type SubmissionDetails struct {
 	Result         string `db:"result" json:"result"`
 	PretestResult  string `db:"pretest_result" json:"pretest_result"`
 	ExtraResult    string `db:"extra_result" json:"extra_result"`
+	JudgeError     string `db:"judge_error" json:"judge_error"` //diagnostic of the last internal error
 }
 
 type Submission struct {
@@ -60,6 +64,7 @@ type Submission struct {
 	SampleScore   float64   `db:"sample_score" json:"sample_score"`
 	Accepted      int       `db:"accepted" json:"accepted"`
 	Length        int       `db:"length" json:"length"`
+	Verdict       string    `db:"verdict" json:"verdict"` //overall verdict of tests, see VerdictOf
 
 	Details SubmissionDetails `json:"details"`
 	Uuid    int64             //useless field for submission query
@@ -77,7 +82,7 @@ const (
 	TestsAccepted   = 2
 	ExtraAccepted   = 4
 	Accepted        = 7
-	submColumns     = "submission_id, submitter, problem_id, contest_id, status, score, time, memory, language, submit_time, sample_score, accepted, length"
+	submColumns     = "submission_id, submitter, problem_id, contest_id, status, score, time, memory, language, submit_time, sample_score, accepted, length, verdict"
 )
 
 /*
@@ -104,14 +109,17 @@ Process a whole judge on single problem submission
 */
 func SubmJudge(sub SubmissionBase, rejudge bool, uuid int64) error {
 	for _, val := range []string{"pretest", "tests", "extra"} {
-		InsertSubmission(int(sub.Id), uuid, SubmPriority(sub.ContestId > 0, rejudge, val), val)
+		err := InsertSubmission(sub.Id, sub.Submitter, uuid, SubmPriority(sub.ContestId > 0, rejudge, val), val)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 func SubmCreate(user_id, problem_id, contest_id int, language utils.LangTag, zipfile []byte, preview map[string]ContentPreview, length int) error {
 	current := utils.TimeStamp()
-	id, err := db.InsertGetId("insert into submissions values (null, ?, ?, ?, ?, 0, -1, -1, ?, ?, 0, 0, ?, ?)", user_id, problem_id, contest_id, Waiting, language, time.Now(), current, length)
+	id, err := db.InsertGetId("insert into submissions values (null, ?, ?, ?, ?, 0, -1, -1, ?, ?, 0, 0, ?, ?, \"\")", user_id, problem_id, contest_id, Waiting, language, time.Now(), current, length)
 	if err != nil {
 		return err
 	}
@@ -119,7 +127,7 @@ func SubmCreate(user_id, problem_id, contest_id int, language utils.LangTag, zip
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec("insert into submission_details values (?, ?, ?, \"\", \"\", \"\")", id, zipfile, js)
+	_, err = db.Exec("insert into submission_details values (?, ?, ?, \"\", \"\", \"\", \"\")", id, zipfile, js)
 	if err != nil {
 		return err
 	}
@@ -194,7 +202,7 @@ func SubmQuery(sid int) (Submission, error) {
 		fmt.Println(err)
 		return ret, err
 	}
-	err = db.SelectSingle(&ret.Details, "select content_preview, result, pretest_result, extra_result from submission_details where submission_id=?", sid)
+	err = db.SelectSingle(&ret.Details, "select content_preview, result, pretest_result, extra_result, judge_error from submission_details where submission_id=?", sid)
 	if err != nil {
 		return ret, err
 	}
@@ -206,14 +214,167 @@ func SubmQuery(sid int) (Submission, error) {
 	return ret, err
 }
 
+// The dumped problem.Submission of a submission, which is a zip file
+func SubmContent(sid int) ([]byte, error) {
+	var content []byte
+	err := db.SelectSingleColumn(&content, "select content from submission_details where submission_id=?", sid)
+	return content, err
+}
+
+// Files in the dumped problem.Submission of a submission, indexed by names in the zip
+func SubmSourceFiles(sid int) (map[string][]byte, error) {
+	content, err := SubmContent(sid)
+	if err != nil {
+		return nil, err
+	}
+	return utils.UnzipMemory(content)
+}
+
+/*
+Unified diffs of files in two submissions, indexed by fields. Files are paired
+by their names without suffixes, so that sources in different languages are
+compared, and fields missing in one submission are compared with empty files.
+Fields with no difference are omitted.
+*/
+func SubmDiff(from, to int) (map[string]string, error) {
+	files_a, fields_a, err := submFields(from)
+	if err != nil {
+		return nil, err
+	}
+	files_b, fields_b, err := submFields(to)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]string)
+	for field, name := range fields_a {
+		other, ok := fields_b[field]
+		if !ok {
+			other = "/dev/null"
+		}
+		diff := UnifiedDiff(name, other, string(files_a[name]), string(files_b[other]))
+		if diff != "" {
+			ret[field] = diff
+		}
+	}
+	for field, name := range fields_b {
+		if _, ok := fields_a[field]; !ok {
+			ret[field] = UnifiedDiff("/dev/null", name, "", string(files_b[name]))
+		}
+	}
+	return ret, nil
+}
+
+// Files of a submission and their names indexed by fields
+func submFields(sid int) (map[string][]byte, map[string]string, error) {
+	files, err := SubmSourceFiles(sid)
+	if err != nil {
+		return nil, nil, err
+	}
+	fields := make(map[string]string)
+	for name := range files {
+		fields[strings.TrimSuffix(name, path.Ext(name))] = name
+	}
+	return files, fields, nil
+}
+
+/*
+Filters of SubmList, zero values and nil pointers don't limit the list.
+
+Verdict is the overall one if Test is 0, or the verdict of testcase Test
+(1-indexed) in mode "tests" otherwise. Scores are inclusive and times are
+compared with submit_time as [After, Before).
+*/
+type SubmFilter struct {
+	Submitter int        `json:"submitter,omitempty"`
+	ProblemId int        `json:"problem_id,omitempty"`
+	ContestId int        `json:"contest_id,omitempty"`
+	Language  *int       `json:"language,omitempty"`
+	Status    *int       `json:"status,omitempty"`
+	Verdict   string     `json:"verdict,omitempty"`
+	Test      int        `json:"test,omitempty"`
+	MinScore  *float64   `json:"min_score,omitempty"`
+	MaxScore  *float64   `json:"max_score,omitempty"`
+	After     *time.Time `json:"after,omitempty"`
+	Before    *time.Time `json:"before,omitempty"`
+	//only submissions accepted by tests
+	Accepted bool `json:"accepted,omitempty"`
+}
+
+// Whether the filter depends on results of tests, which are hidden in pretest-only contests
+func (f *SubmFilter) byTests() bool {
+	return f.Status != nil || f.Verdict != "" || f.MinScore != nil || f.MaxScore != nil || f.Accepted
+}
+
+// Conditions of the filter and their arguments
+func (f *SubmFilter) where() (string, []any, error) {
+	query, args := "", []any{}
+	cond := func(c string, arg ...any) {
+		query += " and " + c
+		args = append(args, arg...)
+	}
+	if f.ProblemId != 0 {
+		cond("problem_id=?", f.ProblemId)
+	}
+	if f.ContestId != 0 {
+		cond("contest_id=?", f.ContestId)
+	}
+	if f.Submitter != 0 {
+		cond("submitter=?", f.Submitter)
+	}
+	if f.Language != nil {
+		cond("language=?", *f.Language)
+	}
+	if f.Status != nil {
+		cond("status=?", *f.Status)
+	}
+	if f.Verdict != "" {
+		if !VerdictValid(f.Verdict) {
+			return "", nil, fmt.Errorf("invalid verdict: %s", f.Verdict)
+		}
+		if f.Test > 0 {
+			cond("exists (select 1 from submission_verdicts as v where v.submission_id=submissions.submission_id and v.mode=\"tests\" and v.testcase=? and v.verdict=?)", f.Test, f.Verdict)
+		} else {
+			cond("verdict=?", f.Verdict)
+		}
+	}
+	if f.MinScore != nil {
+		cond("score>=?", *f.MinScore)
+	}
+	if f.MaxScore != nil {
+		cond("score<=?", *f.MaxScore)
+	}
+	if f.After != nil {
+		cond("submit_time>=?", *f.After)
+	}
+	if f.Before != nil {
+		cond("submit_time<?", *f.Before)
+	}
+	if f.Accepted {
+		cond("accepted&?<>0", TestsAccepted)
+	}
+	return query, args, nil
+}
+
+// All submissions matching the filter regardless of permissions
+func SubmSelect(filter SubmFilter) ([]SubmissionBase, error) {
+	query, args, err := filter.where()
+	if err != nil {
+		return nil, err
+	}
+	var ret []SubmissionBase
+	err = db.SelectAll(&ret, "select submission_id, problem_id, contest_id, submitter from submissions where 1"+query+" order by submission_id", args...)
+	return ret, err
+}
+
 /*
-For params problem_id, contest_id, submitter, if you do not want to limit them then just leave them as 0.
 user_id is the current user's id
 */
-func SubmList(bound, pagesize, user_id, submitter, problem_id, contest_id int, isleft, isadmin bool) ([]Submission, bool, error) {
-	query := utils.If(problem_id == 0, "", fmt.Sprintf(" and problem_id=%d", problem_id)) +
-		utils.If(contest_id == 0, "", fmt.Sprintf(" and contest_id=%d", contest_id)) +
-		utils.If(submitter == 0, "", fmt.Sprintf(" and submitter=%d", submitter))
+func SubmList(bound, pagesize, user_id int, filter SubmFilter, isleft, isadmin bool) ([]Submission, bool, error) {
+	problem_id, contest_id, submitter := filter.ProblemId, filter.ContestId, filter.Submitter
+	query, args, err := filter.where()
+	if err != nil {
+		return nil, false, err
+	}
 	must := "1"
 	if !isadmin {
 		perms, err := UserPermissions(user_id)
@@ -246,6 +407,16 @@ func SubmList(bound, pagesize, user_id, submitter, problem_id, contest_id int, i
 				conts[i] = 0
 			}
 		}
+		if filter.byTests() {
+			//results of tests are hidden to participants of running pretest-only contests
+			conts_pretest, err := db.SelectInts("select a.contest_id from ((select contest_id from contests where start_time<=? and end_time>=? and pretest=1) as a join (select contest_id from contest_participants where user_id=?) as b on a.contest_id=b.contest_id)", time.Now(), time.Now(), user_id)
+			if err != nil {
+				return nil, false, err
+			}
+			if len(conts_pretest) > 0 {
+				query += " and (contest_id is null or contest_id not in (" + utils.JoinArray(conts_pretest) + "))"
+			}
+		}
 
 		must = "("
 		if problem_id == 0 {
@@ -270,11 +441,11 @@ func SubmList(bound, pagesize, user_id, submitter, problem_id, contest_id int, i
 	}
 	pagesize += 1
 	var submissions []Submission
-	var err error
+	args = append([]any{bound}, append(args, pagesize)...)
 	if isleft {
-		err = db.SelectAll(&submissions, fmt.Sprintf("select %s from submissions where submission_id<=%d and %s %s order by submission_id desc limit %d", submColumns, bound, must, query, pagesize))
+		err = db.SelectAll(&submissions, fmt.Sprintf("select %s from submissions where submission_id<=? and %s %s order by submission_id desc limit ?", submColumns, must, query), args...)
 	} else {
-		err = db.SelectAll(&submissions, fmt.Sprintf("select %s from submissions where submission_id>=%d and %s %s order by submission_id limit %d", submColumns, bound, must, query, pagesize))
+		err = db.SelectAll(&submissions, fmt.Sprintf("select %s from submissions where submission_id>=? and %s %s order by submission_id limit ?", submColumns, must, query), args...)
 	}
 	if err != nil {
 		return nil, false, err
@@ -294,6 +465,7 @@ func SubmPretestOnly(sub *Submission) {
 	sub.Score = sub.SampleScore
 	sub.Details.ExtraResult, sub.Details.Result = "", ""
 	sub.Time, sub.Memory = -1, -1
+	sub.Verdict = ""
 	if (sub.Status & JudgingPretest) != 0 {
 		sub.Status = Finished
 	}
@@ -301,9 +473,26 @@ func SubmPretestOnly(sub *Submission) {
 
 var sm_update_mutex = sync.Mutex{}
 
+// Record the result of mode given by a judger, an empty or invalid result is an internal error
 func SubmUpdate(sid, pid int, mode string, result []byte) error {
 	sm_update_mutex.Lock()
 	defer sm_update_mutex.Unlock()
+	res, err := JudgeResultParse(result)
+	if err != nil {
+		return submFail(sid, mode, fmt.Sprintf("%s: invalid result: %v", mode, err))
+	}
+	return submSave(sid, pid, mode, res, result)
+}
+
+// Finish mode without judging since the problem has no data for it
+func submNoData(sid, pid int, mode string) error {
+	sm_update_mutex.Lock()
+	defer sm_update_mutex.Unlock()
+	return submSave(sid, pid, mode, &JudgeResult{Subtask: []SubtaskResult{}}, []byte{})
+}
+
+// You should hold sm_update_mutex before calling this function.
+func submSave(sid, pid int, mode string, res *JudgeResult, result []byte) error {
 	prob := ProbLoad(pid)
 	var testdata *problem.TestdataInfo
 	var column_name string
@@ -319,50 +508,16 @@ func SubmUpdate(sid, pid int, mode string, result []byte) error {
 		column_name = "extra_result"
 	}
 
-	var score, time_used, memory_used float64 = 0, 0, 0
-	accepted := true
-	var err error
-	res_map := make(map[string]any)
-	err = jsoniter.Unmarshal(result, &res_map)
-	is_subtask, has_data := res_map["IsSubtask"].(bool)
-
-	if err == nil && has_data {
-		for _, subtask := range res_map["Subtask"].([]any) {
-			var sub_score float64
-			first := true
-			for _, test := range subtask.(map[string]any)["Testcase"].([]any) {
-				test_score := test.(map[string]any)["Score"].(float64)
-				time_used += test.(map[string]any)["Time"].(float64)
-				memory_used = utils.Max(memory_used, test.(map[string]any)["Memory"].(float64))
-				if first {
-					sub_score = test_score
-					first = false
-					continue
-				}
-				if is_subtask {
-					switch testdata.CalcMethod {
-					case problem.Mmin:
-						sub_score = utils.Min(sub_score, test_score)
-					case problem.Mmax:
-						sub_score = utils.Max(sub_score, test_score)
-					case problem.Msum:
-						sub_score += test_score
-					}
-				} else {
-					sub_score += test_score
-				}
-			}
-			score += sub_score
-			if sub_score != subtask.(map[string]any)["Fullscore"].(float64) {
-				accepted = false
-			}
-		}
+	score, time_used, memory_used, accepted := res.Summary(testdata)
+	err := submSaveVerdicts(sid, mode, res)
+	if err != nil {
+		return err
 	}
 
 	//'and status>=0' means when meets an internal error, we shouldn't update status
 	if mode == "tests" {
-		_, err = db.Exec("update submissions set status=status|?, accepted=accepted|?, score=?, time=?, memory=? where submission_id=? and status>=0",
-			JudgingTests, utils.If(accepted, TestsAccepted, 0), score, int(time_used/float64(time.Millisecond)), int(memory_used/1024), sid)
+		_, err = db.Exec("update submissions set status=status|?, accepted=accepted|?, score=?, time=?, memory=?, verdict=? where submission_id=? and status>=0",
+			JudgingTests, utils.If(accepted, TestsAccepted, 0), score, int(time_used/float64(time.Millisecond)), int(memory_used/1024), res.Verdict(accepted), sid)
 	} else if mode == "pretest" {
 		_, err = db.Exec("update submissions set status=status|?, accepted=accepted|?, sample_score=? where submission_id=? and status>=0",
 			JudgingPretest, utils.If(accepted, PretestAccepted, 0), score, sid)
@@ -377,11 +532,37 @@ func SubmUpdate(sid, pid int, mode string, result []byte) error {
 	if err != nil {
 		return err
 	}
+	return submJudged(sid, mode)
+}
+
+/*
+Finish the judging of mode with an internal error, diagnostic is stored for
+admins. Results of other modes are cleared.
+*/
+func submFail(sid int, mode string, diagnostic string) error {
+	_, err := db.Exec("update submissions set status=?, verdict=? where submission_id=?", InternalError, VerdictIE, sid)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("delete from submission_verdicts where submission_id=?", sid)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("update submission_details set result=\"\", pretest_result=\"\", extra_result=\"\", judge_error=? where submission_id=?", diagnostic, sid)
+	if err != nil {
+		return err
+	}
+	return submJudged(sid, mode)
+}
+
+// Register AfterSubmModeJudge, and AfterSubmJudge if the submission is done. You should hold sm_update_mutex before calling this function.
+func submJudged(sid int, mode string) error {
+	Register("AfterSubmModeJudge", sid, mode)
 	var subinfo struct {
 		SubmissionBase
 		Status int `db:"status"`
 	}
-	err = db.SelectSingle(&subinfo, "select submission_id, problem_id, contest_id, status from submissions where submission_id=?", sid)
+	err := db.SelectSingle(&subinfo, "select submission_id, problem_id, contest_id, status from submissions where submission_id=?", sid)
 	if err != nil {
 		return err
 	}
@@ -392,18 +573,59 @@ func SubmUpdate(sid, pid int, mode string, result []byte) error {
 	return nil
 }
 
-func SubmJudgeCustomTest(content []byte) []byte {
-	callback := make(chan []byte)
-	//find a free submission_id
-	sid, err := db.InsertGetId("insert into custom_tests values (null, ?)", content)
+// Finish the judging of mode with an internal error, see submFail
+func SubmFail(sid int, mode string, diagnostic string) error {
+	sm_update_mutex.Lock()
+	defer sm_update_mutex.Unlock()
+	return submFail(sid, mode, diagnostic)
+}
+
+type CustomTest struct {
+	Id         int        `db:"id" json:"id"`
+	UserId     int        `db:"user_id" json:"user_id"`
+	Result     []byte     `db:"result" json:"-"`
+	CreateTime time.Time  `db:"create_time" json:"create_time"`
+	FinishTime *time.Time `db:"finish_time" json:"finish_time"` //nil if it's not judged yet
+}
+
+// Push a custom test into the judging queue, returns the id to query its result.
+func SubmJudgeCustomTest(user int, language utils.LangTag, content []byte) (int, error) {
+	id, err := db.InsertGetId("insert into custom_tests values (null, ?, ?, ?, null, ?, null)", user, language, content, time.Now())
 	if err != nil {
-		fmt.Println(err)
-		return []byte{}
+		return 0, err
+	}
+	err = InsertCustomTest(int(id), user)
+	if err != nil {
+		db.Exec("delete from custom_tests where id=?", id)
+		return 0, err
+	}
+	return int(id), nil
+}
+
+func SubmQueryCustomTest(id int) (CustomTest, error) {
+	var ret CustomTest
+	err := db.SelectSingle(&ret, "select id, user_id, result, create_time, finish_time from custom_tests where id=?", id)
+	return ret, err
+}
+
+// Save the result of a custom test, an empty result means an internal error.
+func customTestFinish(id int, result []byte) error {
+	_, err := db.Exec("update custom_tests set content=null, result=?, finish_time=? where id=?", result, time.Now(), id)
+	return err
+}
+
+// Delete results of custom tests finished config.Global.CustomTestExpire seconds ago, they never expire if it's not positive
+func customTestCleanStart() {
+	if config.Global.CustomTestExpire <= 0 {
+		return
+	}
+	expire := time.Duration(config.Global.CustomTestExpire) * time.Second
+	for range time.Tick(time.Minute) {
+		_, err := db.Exec("delete from custom_tests where finish_time < ?", time.Now().Add(-expire))
+		if err != nil {
+			fmt.Println(err)
+		}
 	}
-	InsertCustomTest(int(sid), &callback)
-	result := <-callback
-	go db.Exec("delete from custom_tests where id=?", sid)
-	return result
 }
 
 func SubmDelete(sub SubmissionBase) error {
@@ -415,6 +637,10 @@ func SubmDelete(sub SubmissionBase) error {
 	if err != nil {
 		return err
 	}
+	_, err = db.Exec("delete from submission_verdicts where submission_id=?", sub.Id)
+	if err != nil {
+		return err
+	}
 	Register("AfterSubmDelete", sub)
 	return nil
 }
@@ -426,29 +652,37 @@ func SubmRejudge(submission_id int) error {
 	}
 	//update uuid to cancel other entries in the judging queue
 	current := utils.TimeStamp()
-	_, err = db.Exec("update submissions set uuid=?, status=0, accepted=0 where submission_id=?", current, submission_id)
+	_, err = db.Exec("update submissions set uuid=?, status=0, accepted=0, verdict=\"\" where submission_id=?", current, submission_id)
 	if err != nil {
 		return err
 	}
+	db.Exec("update submission_details set judge_error=\"\" where submission_id=?", submission_id)
+	db.Exec("delete from submission_verdicts where submission_id=?", submission_id)
 	Register("OnSubmRejudge", sub)
 	return SubmJudge(sub, true, current)
 }
 
+// Remove files of testcases from a result, other fields are kept as judgers report them
 func SubmRemoveTestDetails(js string) string {
-	if js == "" {
+	var val map[string]any
+	err := jsoniter.UnmarshalFromString(js, &val)
+	if err != nil {
 		return ""
 	}
-	var val map[string]any
-	jsoniter.UnmarshalFromString(js, &val)
-	if val["Subtask"] == nil {
+	subtasks, ok := val["Subtask"].([]any)
+	if !ok {
 		return ""
 	}
-	for _, subtask := range val["Subtask"].([]any) {
-		if subtask.(map[string]any)["Testcase"] == nil {
+	for _, subtask := range subtasks {
+		subtask, ok := subtask.(map[string]any)
+		if !ok {
 			continue
 		}
-		for _, test := range subtask.(map[string]any)["Testcase"].([]any) {
-			test.(map[string]any)["File"] = nil
+		tests, _ := subtask["Testcase"].([]any)
+		for _, test := range tests {
+			if test, ok := test.(map[string]any); ok {
+				test["File"] = nil
+			}
 		}
 	}
 	ret, err := jsoniter.MarshalToString(val)
@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"yao/config"
+	"yao/db"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+func TestSubmUpdateNoData(t *testing.T) {
+	testDB(t)
+	pid, _ := testProblem(t, "tests")
+	sub := testSubmission(t, 1, pid, 0)
+	var uuid int64
+	err := db.SelectSingleColumn(&uuid, "select uuid from submissions where submission_id=?", sub.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the problem has no pretest, so there's nothing to give to judgers
+	job, err := judgeLoad(&JudgeEntry{sid: sub.Id, mode: "pretest", uuid: uuid, submitter: sub.Submitter})
+	if err != nil || job != nil {
+		t.Fatalf("judgeLoad() = %v, %v, want nothing to judge", job, err)
+	}
+	testWait(t, "pretest to finish", func() bool { return testSubmStatus(t, sub.Id)&JudgingPretest != 0 })
+	if status := testSubmStatus(t, sub.Id); status < 0 {
+		t.Fatalf("status = %d, a mode without data shouldn't be an internal error", status)
+	}
+	var details SubmissionDetails
+	err = db.SelectSingle(&details, "select content_preview, result, pretest_result, extra_result, judge_error from submission_details where submission_id=?", sub.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.JudgeError != "" {
+		t.Fatalf("judge error %q", details.JudgeError)
+	}
+}
+
+func TestSubmUpdateEmpty(t *testing.T) {
+	testDB(t)
+	pid, _ := testProblem(t, "tests")
+	sub := testSubmission(t, 1, pid, 0)
+	//an empty callback from a judger must not be recorded as accepted
+	err := SubmUpdate(sub.Id, pid, "tests", []byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := testSubmStatus(t, sub.Id); status != InternalError {
+		t.Fatalf("status = %d, want %d", status, InternalError)
+	}
+}
+
+func TestSubmRemoveTestDetails(t *testing.T) {
+	js := `{"IsSubtask":true,"Judger":"v2","Subtask":[{"Fullscore":100,"Note":"x","Testcase":[
+		{"Title":"Accepted","Score":100,"Time":1,"Memory":2,"Checker":"ok","File":[{"Title":"input","Content":"1 2"}]}]}]}`
+	want := `{"IsSubtask":true,"Judger":"v2","Subtask":[{"Fullscore":100,"Note":"x","Testcase":[
+		{"Title":"Accepted","Score":100,"Time":1,"Memory":2,"Checker":"ok","File":null}]}]}`
+	var got_val, want_val any
+	if err := jsoniter.UnmarshalFromString(SubmRemoveTestDetails(js), &got_val); err != nil {
+		t.Fatal(err)
+	}
+	jsoniter.UnmarshalFromString(want, &want_val)
+	if !reflect.DeepEqual(got_val, want_val) {
+		t.Errorf("got %v, want %v", got_val, want_val)
+	}
+	for _, js := range []string{"", "{", `{"Subtask":1}`, `[]`} {
+		if got := SubmRemoveTestDetails(js); got != "" {
+			t.Errorf("SubmRemoveTestDetails(%q) = %q, want empty", js, got)
+		}
+	}
+}
+
+func TestCustomTestNeverExpire(t *testing.T) {
+	old := config.Global.CustomTestExpire
+	t.Cleanup(func() { config.Global.CustomTestExpire = old })
+	for _, expire := range []int{0, -1} {
+		config.Global.CustomTestExpire = expire
+		done := make(chan struct{})
+		go func() {
+			customTestCleanStart()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("the cleaner is started with custom_test_expire %d", expire)
+		}
+	}
+}
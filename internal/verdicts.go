@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"strings"
+	"time"
+	"yao/db"
+)
+
+// Verdict codes of testcases and submissions
+const (
+	VerdictAC  = "AC" //accepted
+	VerdictPC  = "PC" //partially correct
+	VerdictWA  = "WA" //wrong answer
+	VerdictTLE = "TLE"
+	VerdictMLE = "MLE"
+	VerdictOLE = "OLE"
+	VerdictRE  = "RE"  //runtime error
+	VerdictCE  = "CE"  //compile error
+	VerdictIE  = "IE"  //internal error
+	VerdictUKE = "UKE" //unknown titles reported by judgers
+)
+
+var verdictTitles = map[string]string{
+	"accepted":              VerdictAC,
+	"partially correct":     VerdictPC,
+	"partial":               VerdictPC,
+	"wrong answer":          VerdictWA,
+	"time limit exceeded":   VerdictTLE,
+	"memory limit exceeded": VerdictMLE,
+	"output limit exceeded": VerdictOLE,
+	"runtime error":         VerdictRE,
+	"dangerous syscall":     VerdictRE,
+	"compile error":         VerdictCE,
+	"compilation error":     VerdictCE,
+	"internal error":        VerdictIE,
+	"system error":          VerdictIE,
+}
+
+// Verdict code of a testcase title reported by judgers
+func VerdictOf(title string) string {
+	title = strings.ToLower(strings.TrimSpace(title))
+	if verdict, ok := verdictTitles[title]; ok {
+		return verdict
+	}
+	if VerdictValid(strings.ToUpper(title)) {
+		return strings.ToUpper(title)
+	}
+	return VerdictUKE
+}
+
+func VerdictValid(verdict string) bool {
+	switch verdict {
+	case VerdictAC, VerdictPC, VerdictWA, VerdictTLE, VerdictMLE, VerdictOLE, VerdictRE, VerdictCE, VerdictIE, VerdictUKE:
+		return true
+	}
+	return false
+}
+
+// Overall verdict: accepted, or the verdict of the first testcase not accepted
+func (res *JudgeResult) Verdict(accepted bool) string {
+	if accepted {
+		return VerdictAC
+	}
+	for _, subtask := range res.Subtask {
+		for _, test := range subtask.Testcase {
+			if verdict := VerdictOf(test.Title); verdict != VerdictAC {
+				return verdict
+			}
+		}
+	}
+	//every testcase is accepted but some subtask doesn't get the full score
+	return VerdictPC
+}
+
+/*
+Save verdicts of all testcases of mode into table submission_verdicts.
+Testcases are numbered from 1 through all subtasks, subtasks are numbered from 1.
+*/
+func submSaveVerdicts(sid int, mode string, res *JudgeResult) error {
+	_, err := db.Exec("delete from submission_verdicts where submission_id=? and mode=?", sid, mode)
+	if err != nil {
+		return err
+	}
+	values := []string{}
+	args := []any{}
+	for i, subtask := range res.Subtask {
+		for _, test := range subtask.Testcase {
+			values = append(values, "(?, ?, ?, ?, ?, ?, ?, ?)")
+			args = append(args, sid, mode, i+1, len(values), VerdictOf(test.Title), test.Score, int(test.Time/float64(time.Millisecond)), int(test.Memory/1024))
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	_, err = db.Exec("insert into submission_verdicts values "+strings.Join(values, ", "), args...)
+	return err
+}
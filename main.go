@@ -55,6 +55,7 @@ func main() {
 	}
 	defer db.Close()
 	go internal.JudgersInit()
+	internal.PlagiarismInit()
 	captcha.SetCustomStore(captcha.NewMemoryStore(1024, 10*time.Minute))
 
 	// server init
@@ -243,6 +243,14 @@ func (p *Permit) TryEditBlogCmnt(cmntid int) *Permit {
 	})
 }
 
+// custom tests can be seen by their owners and admins
+func (p *Permit) TrySeeCustomTest(id int) *Permit {
+	return p.Try(func() (any, bool) {
+		test, err := internal.SubmQueryCustomTest(id)
+		return test, err == nil && (p.IsAdmin() || test.UserId == p.UserID)
+	})
+}
+
 type PermitSubm struct {
 	internal.Submission
 	ByProb  bool
@@ -261,6 +269,43 @@ func (p *Permit) TrySeeSubm(submid int) *Permit {
 	})
 }
 
+// Same as TrySeeSubm, but others' code of contest submissions is hidden until the contest ends
+func (p *Permit) TrySeeSubmSource(submid int) *Permit {
+	return p.TrySeeSubm(submid).Then(func(a any) (any, bool) {
+		psubm := a.(PermitSubm)
+		if psubm.CanEdit || psubm.Submitter == p.UserID || psubm.ContestId == 0 {
+			return a, true
+		}
+		ctst, err := internal.CTQuery(psubm.ContestId, p.UserID)
+		return a, err == nil && ctst.EndTime.Before(time.Now())
+	})
+}
+
+// Plagiarism jobs of contests are reviewed by contest managers, others by problem managers
+func (p *Permit) TryReviewPlag(jobid int) *Permit {
+	return p.Try(func() (any, bool) {
+		job, err := internal.PlagiarismQuery(jobid)
+		if err != nil {
+			return nil, false
+		}
+		if job.ContestId > 0 {
+			return job, p.CanEditCtst(job.ContestId)
+		}
+		return job, p.CanEditProb(job.ProblemId)
+	})
+}
+
+// Rejudge jobs are managed by admins and their creators
+func (p *Permit) TryManageRejudge(jobid int) *Permit {
+	return p.Try(func() (any, bool) {
+		job, err := internal.RejudgeQuery(jobid)
+		if err != nil {
+			return nil, false
+		}
+		return job, p.IsAdmin() || job.Creator == p.UserID
+	})
+}
+
 func (p *Permit) TryEditSubm(submid int) *Permit {
 	return p.Try(func() (any, bool) {
 		ret, _ := internal.SubmGetBaseInfo(submid)
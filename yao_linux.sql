@@ -259,8 +259,14 @@ DROP TABLE IF EXISTS `custom_tests`;
  SET character_set_client = utf8mb4 ;
 CREATE TABLE `custom_tests` (
   `id` int(11) NOT NULL AUTO_INCREMENT,
+  `user_id` int(11) DEFAULT NULL,
+  `language` int(11) DEFAULT NULL,
   `content` mediumblob,
-  PRIMARY KEY (`id`)
+  `result` mediumblob,
+  `create_time` datetime DEFAULT NULL,
+  `finish_time` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `finish_time` (`finish_time`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
@@ -388,6 +394,7 @@ CREATE TABLE `submission_details` (
   `result` mediumblob,
   `pretest_result` mediumblob,
   `extra_result` mediumblob,
+  `judge_error` text,
   PRIMARY KEY (`submission_id`)
 ) ENGINE=MyISAM DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 /*!40101 SET character_set_client = @saved_cs_client */;
@@ -423,11 +430,13 @@ CREATE TABLE `submissions` (
   `accepted` int(11) DEFAULT NULL,
   `uuid` bigint(20) DEFAULT NULL,
   `length` int(11) DEFAULT NULL,
+  `verdict` varchar(8) DEFAULT NULL,
   PRIMARY KEY (`submission_id`),
   KEY `contest_id` (`contest_id`),
   KEY `status` (`status`),
   KEY `submitter` (`submitter`,`accepted`),
-  KEY `problem_id` (`problem_id`,`accepted`)
+  KEY `problem_id` (`problem_id`,`accepted`),
+  KEY `verdict` (`verdict`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
@@ -520,4 +529,122 @@ CREATE TABLE `contest_dashboard` (
   `id` int(11) NOT NULL AUTO_INCREMENT,
   PRIMARY KEY (`id`),
   KEY `contest_id` (`contest_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+--
+-- Table structure for table `judge_queue`
+--
+
+DROP TABLE IF EXISTS `judge_queue`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+ SET character_set_client = utf8mb4 ;
+CREATE TABLE `judge_queue` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `submission_id` int(11) DEFAULT NULL,
+  `mode` varchar(20) DEFAULT NULL,
+  `uuid` bigint(20) DEFAULT NULL,
+  `submitter` int(11) DEFAULT NULL,
+  `priority` int(11) DEFAULT NULL,
+  `enqueue_time` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `submission_id` (`submission_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+--
+-- Table structure for table `submission_verdicts`
+--
+
+DROP TABLE IF EXISTS `submission_verdicts`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+ SET character_set_client = utf8mb4 ;
+CREATE TABLE `submission_verdicts` (
+  `submission_id` int(11) NOT NULL,
+  `mode` varchar(20) NOT NULL,
+  `subtask` int(11) DEFAULT NULL,
+  `testcase` int(11) NOT NULL,
+  `verdict` varchar(8) DEFAULT NULL,
+  `score` float DEFAULT NULL,
+  `time` int(11) DEFAULT NULL,
+  `memory` int(11) DEFAULT NULL,
+  PRIMARY KEY (`submission_id`,`mode`,`testcase`),
+  KEY `verdict` (`mode`,`testcase`,`verdict`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+--
+-- Table structure for table `plagiarism_jobs`
+--
+
+DROP TABLE IF EXISTS `plagiarism_jobs`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+ SET character_set_client = utf8mb4 ;
+CREATE TABLE `plagiarism_jobs` (
+  `job_id` int(11) NOT NULL AUTO_INCREMENT,
+  `contest_id` int(11) DEFAULT NULL,
+  `problem_id` int(11) DEFAULT NULL,
+  `threshold` float DEFAULT NULL,
+  `status` varchar(20) DEFAULT NULL,
+  `error` text,
+  `create_time` datetime DEFAULT NULL,
+  `finish_time` datetime DEFAULT NULL,
+  PRIMARY KEY (`job_id`),
+  KEY `contest_id` (`contest_id`),
+  KEY `problem_id` (`problem_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+--
+-- Table structure for table `plagiarism_pairs`
+--
+
+DROP TABLE IF EXISTS `plagiarism_pairs`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+ SET character_set_client = utf8mb4 ;
+CREATE TABLE `plagiarism_pairs` (
+  `job_id` int(11) NOT NULL,
+  `submission_a` int(11) NOT NULL,
+  `submission_b` int(11) NOT NULL,
+  `similarity` float DEFAULT NULL,
+  PRIMARY KEY (`job_id`,`submission_a`,`submission_b`),
+  KEY `similarity` (`job_id`,`similarity`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+--
+-- Table structure for table `rejudge_jobs`
+--
+
+DROP TABLE IF EXISTS `rejudge_jobs`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+ SET character_set_client = utf8mb4 ;
+CREATE TABLE `rejudge_jobs` (
+  `job_id` int(11) NOT NULL AUTO_INCREMENT,
+  `creator` int(11) DEFAULT NULL,
+  `filter` text,
+  `queued` int(11) DEFAULT NULL,
+  `done` int(11) DEFAULT NULL,
+  `failed` int(11) DEFAULT NULL,
+  `cancelled` int(11) DEFAULT NULL,
+  `status` varchar(20) DEFAULT NULL,
+  `create_time` datetime DEFAULT NULL,
+  `finish_time` datetime DEFAULT NULL,
+  PRIMARY KEY (`job_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+--
+-- Table structure for table `rejudge_job_items`
+--
+
+DROP TABLE IF EXISTS `rejudge_job_items`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+ SET character_set_client = utf8mb4 ;
+CREATE TABLE `rejudge_job_items` (
+  `job_id` int(11) NOT NULL,
+  `submission_id` int(11) NOT NULL,
+  `uuid` bigint(20) DEFAULT NULL,
+  `state` int(11) DEFAULT NULL,
+  `old_score` float DEFAULT NULL,
+  `old_accepted` int(11) DEFAULT NULL,
+  `old_time` int(11) DEFAULT NULL,
+  `old_memory` int(11) DEFAULT NULL,
+  `old_verdict` varchar(8) DEFAULT NULL,
+  PRIMARY KEY (`job_id`,`submission_id`),
+  KEY `submission_id` (`submission_id`,`state`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
\ No newline at end of file